@@ -0,0 +1,190 @@
+package rsutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	errXattrNotFound    = errors.New("Extended attribute not found")
+	errXattrUnsupported = errors.New("Extended attributes are not supported")
+)
+
+const verifiedXattr = "user.rsutils.verified"
+
+// ChangeDetector decides whether a data or parity shard has to be verified
+// again before it is read. FileDecoder calls Changed before verifying a shard
+// and MarkVerified once the shard matched its hash. Shards are identified by
+// the file they are stored in and their index, data shards first.
+type ChangeDetector interface {
+	// Changed reports whether the shard may have changed since MarkVerified
+	// was last called with it.
+	Changed(f *os.File, shard int) (bool, error)
+	// MarkVerified records that the shard was intact while f had the
+	// Fingerprint fp. fp is taken before the shard was hashed, so a write
+	// racing with the hashing leaves f with a different fingerprint and the
+	// shard is reported as changed again.
+	MarkVerified(f *os.File, shard int, fp Fingerprint) error
+}
+
+type shardKey struct {
+	f     *os.File
+	shard int
+}
+
+// Fingerprint identifies the state of a file without reading it. Inode
+// numbers and change times are only filled in on Linux.
+type Fingerprint struct {
+	Size  int64
+	MTime int64
+	Inode uint64
+	CTime int64
+}
+
+// FingerprintFile returns the current Fingerprint of f.
+func FingerprintFile(f *os.File) (Fingerprint, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	return statFingerprint(fi), nil
+}
+
+type alwaysVerifyDetector struct{}
+
+// NewAlwaysVerifyDetector returns a ChangeDetector that reports every shard as
+// changed, so data is verified on every read. It is the only detector that
+// catches bit rot and writes that bypass the filesystem, at the cost of
+// hashing the shards on every read.
+func NewAlwaysVerifyDetector() ChangeDetector {
+	return alwaysVerifyDetector{}
+}
+
+func (alwaysVerifyDetector) Changed(f *os.File, shard int) (bool, error) {
+	return true, nil
+}
+
+func (alwaysVerifyDetector) MarkVerified(f *os.File, shard int, fp Fingerprint) error {
+	return nil
+}
+
+type statChangeDetector struct {
+	mu       sync.Mutex
+	verified map[shardKey]Fingerprint
+}
+
+// NewStatChangeDetector returns a ChangeDetector that reports a shard as
+// changed when the size, modification time, inode number or change time of its
+// file differ from when the shard was last verified. The change time is
+// updated by the kernel on every write and can't be set by users, so unlike the
+// modification time alone it catches edits that keep the same timestamp. Inode
+// numbers and change times are only available on Linux. This is the default
+// used by Open.
+func NewStatChangeDetector() ChangeDetector {
+	return &statChangeDetector{verified: make(map[shardKey]Fingerprint)}
+}
+
+func (d *statChangeDetector) Changed(f *os.File, shard int) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fp, ok := d.verified[shardKey{f, shard}]
+	return !ok || fp != statFingerprint(fi), nil
+}
+
+func (d *statChangeDetector) MarkVerified(f *os.File, shard int, fp Fingerprint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.verified[shardKey{f, shard}] = fp
+	return nil
+}
+
+type xattrChangeDetector struct {
+	fallback *statChangeDetector
+}
+
+// NewXattrChangeDetector returns a ChangeDetector that stores the fingerprint
+// of every file together with the shards verified since it last changed in its
+// user.rsutils.verified extended attribute, so the verified state survives
+// across processes and Open calls. Setting the attribute updates the change
+// time of the file, so only size, modification time and inode number are
+// compared. On filesystems without extended attribute support it behaves like
+// NewStatChangeDetector.
+func NewXattrChangeDetector() ChangeDetector {
+	return &xattrChangeDetector{fallback: NewStatChangeDetector().(*statChangeDetector)}
+}
+
+func (d *xattrChangeDetector) Changed(f *os.File, shard int) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	verifiedShards, err := d.verifiedShards(f, statFingerprint(fi))
+	if err == errXattrUnsupported {
+		return d.fallback.Changed(f, shard)
+	}
+	if err != nil {
+		return false, err
+	}
+	return !verifiedShards[shard], nil
+}
+
+func (d *xattrChangeDetector) MarkVerified(f *os.File, shard int, fp Fingerprint) error {
+	verifiedShards, err := d.verifiedShards(f, fp)
+	if err == errXattrUnsupported {
+		return d.fallback.MarkVerified(f, shard, fp)
+	}
+	if err != nil {
+		return err
+	}
+
+	value := xattrFingerprint(fp)
+	for i := range verifiedShards {
+		if i != shard {
+			value += fmt.Sprintf(" %d", i)
+		}
+	}
+	value += fmt.Sprintf(" %d", shard)
+	err = setXattr(f.Name(), verifiedXattr, []byte(value))
+	if err == errXattrUnsupported {
+		return d.fallback.MarkVerified(f, shard, fp)
+	}
+	return err
+}
+
+// verifiedShards returns the shards recorded as verified in the attribute of
+// f, if the fingerprint stored with them matches fp.
+func (d *xattrChangeDetector) verifiedShards(f *os.File, fp Fingerprint) (map[int]bool, error) {
+	verifiedShards := make(map[int]bool)
+	value, err := getXattr(f.Name(), verifiedXattr)
+	if err == errXattrNotFound {
+		return verifiedShards, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(string(value))
+	fingerprint := xattrFingerprint(fp)
+	if len(fields) < 3 || strings.Join(fields[:3], " ") != fingerprint {
+		return verifiedShards, nil
+	}
+	for _, field := range fields[3:] {
+		shard, err := strconv.Atoi(field)
+		if err == nil {
+			verifiedShards[shard] = true
+		}
+	}
+	return verifiedShards, nil
+}
+
+func xattrFingerprint(fp Fingerprint) string {
+	return fmt.Sprintf("%d %d %d", fp.Size, fp.MTime, fp.Inode)
+}
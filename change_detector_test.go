@@ -0,0 +1,179 @@
+package rsutils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// rewriteKeepingMTime overwrites the start of f and restores its modification
+// time, like bit rot or a raw device write would.
+func rewriteKeepingMTime(t *testing.T, f *os.File, p []byte) {
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt(p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(f.Name(), fi.ModTime(), fi.ModTime())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangeDetectors(t *testing.T) {
+	tests := []struct {
+		name                  string
+		detector              func() ChangeDetector
+		changedAfterVerify    bool
+		changedAfterSameMTime bool
+		changedForNewDetector bool
+	}{
+		{"always verify", NewAlwaysVerifyDetector, true, true, true},
+		// change times are only compared on Linux
+		{"stat", NewStatChangeDetector, false, runtime.GOOS == "linux", true},
+		{"xattr", NewXattrChangeDetector, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := CreateTMPFile(t, []byte("ABCDEFGH"))
+			if tt.name == "xattr" {
+				if err := setXattr(f.Name(), verifiedXattr, []byte("")); err == errXattrUnsupported {
+					t.Skip("Extended attributes are not supported")
+				}
+			}
+			detector := tt.detector()
+
+			changed, err := detector.Changed(f, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !changed {
+				t.Errorf("Expected unverified file to be reported as changed")
+			}
+
+			fp, err := FingerprintFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = detector.MarkVerified(f, 0, fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed, _ = detector.Changed(f, 0)
+			if changed != tt.changedAfterVerify {
+				t.Errorf("Got changed == %t after MarkVerified, expected %t", changed, tt.changedAfterVerify)
+			}
+			changed, _ = detector.Changed(f, 1)
+			if !changed {
+				t.Errorf("Expected a shard that wasn't verified to be reported as changed")
+			}
+			changed, _ = tt.detector().Changed(f, 0)
+			if changed != tt.changedForNewDetector {
+				t.Errorf("Got changed == %t from a new detector, expected %t", changed, tt.changedForNewDetector)
+			}
+
+			rewriteKeepingMTime(t, f, []byte("abc"))
+			changed, _ = detector.Changed(f, 0)
+			if changed != tt.changedAfterSameMTime {
+				t.Errorf("Got changed == %t after a write that kept mtime, expected %t", changed, tt.changedAfterSameMTime)
+			}
+
+			_, err = f.Write([]byte("ABCDEFGHI"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed, _ = detector.Changed(f, 0)
+			if !changed {
+				t.Errorf("Expected file to be reported as changed after changing its size")
+			}
+		})
+	}
+}
+
+func TestChangeDetectorsCatchWriteDuringVerification(t *testing.T) {
+	tests := []struct {
+		name     string
+		detector func() ChangeDetector
+	}{
+		{"always verify", NewAlwaysVerifyDetector},
+		{"stat", NewStatChangeDetector},
+		{"xattr", NewXattrChangeDetector},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := CreateTMPFile(t, []byte("ABCDEFGH"))
+			detector := tt.detector()
+
+			fp, err := FingerprintFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the file changes while the shard is being hashed
+			_, err = f.WriteAt([]byte("ABCDEFGHI"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = detector.MarkVerified(f, 0, fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed, err := detector.Changed(f, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !changed {
+				t.Errorf("Expected a shard written to during verification to be reported as changed")
+			}
+		})
+	}
+}
+
+func TestFileDecoderDetectsSameMTimeCorruption(t *testing.T) {
+	tests := []struct {
+		name     string
+		detector ChangeDetector
+	}{
+		{"always verify", NewAlwaysVerifyDetector()},
+		{"stat", NewStatChangeDetector()},
+	}
+	expected, err := ioutil.ReadFile("testdata/input3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "stat" && runtime.GOOS != "linux" {
+				t.Skip("Change times are only compared on Linux")
+			}
+			dataInput := cloneFileTmp(t, getTestFile(t, "input3")).(*os.File)
+			parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+
+			decoder, err := Open(dataInput, []*os.File{parityInput}, getMetadata(), WithChangeDetector(tt.detector))
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 16)
+			_, err = decoder.ReadAt(buf, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rewriteKeepingMTime(t, dataInput, []byte("XXXX"))
+			_, err = decoder.ReadAt(buf, 0)
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			if !bytes.Equal(buf, expected[:16]) {
+				t.Errorf("Got '%s', expected '%s'", buf, expected[:16])
+			}
+		})
+	}
+}
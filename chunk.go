@@ -19,13 +19,22 @@ type PaddedFileChunk struct {
 	limit int64
 	// position relative to offset
 	position int64
+	// size of the data; the part of the last chunk past it is padding
+	size int64
 }
 
-func SplitIntoPaddedChunks(src ReadAtWriteAtSeeker, size int64, numChunks int) []*PaddedFileChunk {
+// paddedChunkSize returns the size of each chunk when size bytes are split into
+// numChunks chunks, rounding up so the last chunk is padded.
+func paddedChunkSize(size int64, numChunks int) int64 {
 	chunkSize := size / int64(numChunks)
 	if size%int64(numChunks) != 0 {
 		chunkSize += 1
 	}
+	return chunkSize
+}
+
+func SplitIntoPaddedChunks(src ReadAtWriteAtSeeker, size int64, numChunks int) []*PaddedFileChunk {
+	chunkSize := paddedChunkSize(size, numChunks)
 	readWriteSeekers := make([]*PaddedFileChunk, numChunks)
 	for i := 0; i < numChunks; i++ {
 		readWriteSeekers[i] = &PaddedFileChunk{
@@ -33,57 +42,110 @@ func SplitIntoPaddedChunks(src ReadAtWriteAtSeeker, size int64, numChunks int) [
 			// offset is inclusive, limit exclusive - [offset, limit)
 			offset: int64(i) * chunkSize,
 			limit:  int64(i+1) * chunkSize,
+			size:   size,
 		}
 	}
 	return readWriteSeekers
 }
 
+// Size returns the size of the chunk, including padding.
+func (pfc *PaddedFileChunk) Size() int64 {
+	return pfc.limit - pfc.offset
+}
+
+// readAt reads len(p) bytes starting at off, relative to the beginning of the
+// chunk, filling whatever lies past the end of the underlying data with zeros.
+// p must fit in the chunk.
+func (pfc *PaddedFileChunk) readAt(p []byte, off int64) (int, error) {
+	n, err := pfc.data.ReadAt(p, pfc.offset+off)
+	// if we're reading the last chunk and there is not enough data to fill
+	// the buffer, we fill it with zeroes.
+	if err == io.EOF {
+		copy(p[n:], make([]byte, len(p)-n))
+		return len(p), nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(p) bytes starting at off, relative to the beginning of the
+// chunk, padding past the end of the data with zeros. It doesn't use or move
+// the position of the chunk, so it may be called concurrently.
+func (pfc *PaddedFileChunk) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Requested position %d is smaller than chunk beginning 0", off)
+	}
+	if off >= pfc.Size() {
+		return 0, io.EOF
+	}
+	readBuffer := p
+	if bytesLeft := pfc.Size() - off; int64(len(p)) > bytesLeft {
+		readBuffer = p[:bytesLeft]
+	}
+	n, err := pfc.readAt(readBuffer, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Read reads from the current position of the chunk. The last bytes of a chunk
+// that ends in padding are returned together with io.EOF.
 func (pfc *PaddedFileChunk) Read(p []byte) (n int, err error) {
 	// f chunk is all read
-	if pfc.position == pfc.limit-pfc.offset {
+	if pfc.position == pfc.Size() {
 		return 0, io.EOF
 	}
 
-	var readBuffer []byte = p
-	pBufLen := len(p)
-	bufShrunk := false
-	// if buffer is larger than the chunk, we have to create a smaller buffer
-	// to prevent reading from the next chunk. Then, we will write to original
-	// buffer 'p'.
-	if bytesLeft := pfc.limit - pfc.offset - pfc.position; int64(pBufLen) > bytesLeft {
-		readBuffer = make([]byte, bytesLeft)
-		pBufLen = int(bytesLeft)
-		bufShrunk = true
-	}
-	n, err = pfc.data.ReadAt(readBuffer, pfc.offset+pfc.position)
-	if err != nil {
-		// if we're reading the last chunk and there is not enough data to fill
-		// the buffer, we fill it with zeroes.
-		if err == io.EOF {
-			copy(readBuffer[n:pBufLen], make([]byte, pBufLen-n))
-			n = pBufLen
-		}
+	readBuffer := p
+	// if buffer is larger than the chunk, we have to read into a smaller
+	// buffer to prevent reading from the next chunk.
+	if bytesLeft := pfc.Size() - pfc.position; int64(len(p)) > bytesLeft {
+		readBuffer = p[:bytesLeft]
 	}
+	n, err = pfc.readAt(readBuffer, pfc.position)
 	pfc.position += int64(n)
-	if bufShrunk {
-		copy(p, readBuffer)
+	if err == nil && pfc.position == pfc.Size() && pfc.limit > pfc.size {
+		err = io.EOF
 	}
 	return n, err
 }
 
-func (pfc *PaddedFileChunk) Write(p []byte) (n int, err error) {
+// WriteAt writes p starting at off, relative to the beginning of the chunk.
+// Bytes past the size of the data are padding: they are accepted but not
+// written, so writing a whole padded chunk never grows the underlying file.
+// It doesn't use or move the position of the chunk, so it may be called
+// concurrently.
+func (pfc *PaddedFileChunk) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Requested position %d is smaller than chunk beginning 0", off)
+	}
 	lp := int64(len(p))
-	if bytesLeft := pfc.limit - pfc.offset - pfc.position; lp > bytesLeft {
+	if bytesLeft := pfc.Size() - off; lp > bytesLeft {
 		return 0, fmt.Errorf("Cannot write %d bytes to chunk; Only %d bytes left", lp, bytesLeft)
 	}
-	n, err = pfc.data.WriteAt(p, pfc.offset+pfc.position)
-	if err != nil {
-		return 0, err
+	realBytes := pfc.size - pfc.offset - off
+	if realBytes > lp {
+		realBytes = lp
 	}
+	if realBytes > 0 {
+		_, err = pfc.data.WriteAt(p[:realBytes], pfc.offset+off)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Write writes p at the current position of the chunk, like WriteAt.
+func (pfc *PaddedFileChunk) Write(p []byte) (n int, err error) {
+	n, err = pfc.WriteAt(p, pfc.position)
 	pfc.position += int64(n)
-	return n, nil
+	return n, err
 }
 
+// Seek sets the position of the next Read or Write, relative to the beginning
+// of the chunk. io.SeekEnd is relative to the end of the chunk, including
+// padding. On error the position is left unchanged and returned.
 func (pfc *PaddedFileChunk) Seek(offset int64, whence int) (int64, error) {
 	var position int64
 	switch whence {
@@ -92,16 +154,15 @@ func (pfc *PaddedFileChunk) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		position = pfc.position + offset
 	case io.SeekEnd:
-		position = pfc.limit + offset
+		position = pfc.Size() + offset
 	default:
-		return pfc.offset, fmt.Errorf("Got %d, expected one of: io.SeekStart, io.SeekCurrent, io.SeekEnd", whence)
+		return pfc.position, fmt.Errorf("Got %d, expected one of: io.SeekStart, io.SeekCurrent, io.SeekEnd", whence)
 	}
-	if pfc.offset+position > pfc.limit {
-		return pfc.offset, fmt.Errorf("Requested position %d is larger than chunk limit %d", position, pfc.limit)
-	} else if pfc.offset+position < pfc.offset {
-		return pfc.offset, fmt.Errorf("Requested position %d is smaller than chunk beginning %d", position, pfc.offset)
-	} else {
-		pfc.position = position
-		return pfc.position, nil
+	if position > pfc.Size() {
+		return pfc.position, fmt.Errorf("Requested position %d is larger than chunk limit %d", position, pfc.Size())
+	} else if position < 0 {
+		return pfc.position, fmt.Errorf("Requested position %d is smaller than chunk beginning 0", position)
 	}
+	pfc.position = position
+	return pfc.position, nil
 }
@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -293,3 +294,174 @@ func TestPaddedFileChunkSeekingErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestPaddedFileChunkWritingSkipsPadding(t *testing.T) {
+	tmpFile := CreateTMPFile(t, []byte{})
+	writers := SplitIntoPaddedChunks(tmpFile, 9, 2)
+	inputs := [][]byte{[]byte("ABCDE"), []byte("FGHI\x00")}
+	for i, writer := range writers {
+		n, err := writer.Write(inputs[i])
+		if err != nil {
+			t.Fatalf("Writing to tmp file failed: %s", err)
+		}
+		if n != len(inputs[i]) {
+			t.Errorf("Got %d bytes written, expected %d", n, len(inputs[i]))
+		}
+	}
+
+	b, err := ioutil.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte("ABCDEFGHI")) {
+		t.Errorf("Expected padding not to be written, got %v", b)
+	}
+}
+
+func TestPaddedFileChunkSize(t *testing.T) {
+	tmpFile := CreateTMPFile(t, []byte("ABCDEFGHI"))
+	for i, chunk := range SplitIntoPaddedChunks(tmpFile, 9, 2) {
+		if chunk.Size() != 5 {
+			t.Errorf("Got size %d for chunk %d, expected 5", chunk.Size(), i)
+		}
+	}
+}
+
+func TestPaddedFileChunkReadAt(t *testing.T) {
+	tests := []struct {
+		name          string
+		chunk         int
+		off           int64
+		bufLen        int
+		expectedBytes []byte
+		expectedErr   error
+	}{
+		{"first chunk", 0, 1, 3, []byte("BCD"), nil},
+		{"second chunk", 1, 1, 3, []byte("GHI"), nil},
+		{"padding", 1, 2, 3, []byte("HI\x00"), nil},
+		{"past the chunk", 1, 3, 4, []byte("I\x00"), io.EOF},
+		{"at the end", 1, 5, 1, []byte{}, io.EOF},
+	}
+
+	tmpFile := CreateTMPFile(t, []byte("ABCDEFGHI"))
+	chunks := SplitIntoPaddedChunks(tmpFile, 9, 2)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, tt.bufLen)
+			n, err := chunks[tt.chunk].ReadAt(buf, tt.off)
+			if err != tt.expectedErr {
+				t.Errorf("Got error %v, expected %v", err, tt.expectedErr)
+			}
+			if !bytes.Equal(buf[:n], tt.expectedBytes) {
+				t.Errorf("Got %q, expected %q", buf[:n], tt.expectedBytes)
+			}
+		})
+	}
+}
+
+func TestPaddedFileChunkReadAtConcurrent(t *testing.T) {
+	input := make([]byte, 4096)
+	for i := range input {
+		input[i] = byte(i)
+	}
+	tmpFile := CreateTMPFile(t, input)
+	chunk := SplitIntoPaddedChunks(tmpFile, int64(len(input)), 2)[1]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			contents, err := ioutil.ReadAll(io.NewSectionReader(chunk, off, 256))
+			if err != nil {
+				t.Errorf("Expected nil error, got %s", err)
+				return
+			}
+			if expected := input[2048+off : 2048+off+256]; !bytes.Equal(contents, expected) {
+				t.Errorf("Got wrong contents at offset %d", off)
+			}
+		}(int64(i) * 256)
+	}
+	wg.Wait()
+}
+
+func TestPaddedFileChunkWriteAt(t *testing.T) {
+	tmpFile := CreateTMPFile(t, []byte("ABCDEFGHI"))
+	chunks := SplitIntoPaddedChunks(tmpFile, 9, 2)
+
+	n, err := chunks[1].WriteAt([]byte("xyz"), 2)
+	if err != nil || n != 3 {
+		t.Fatalf("Got %d bytes written and error %v, expected 3 and nil", n, err)
+	}
+	expectedErrMsg := "Cannot write 3 bytes to chunk; Only 2 bytes left"
+	_, err = chunks[0].WriteAt([]byte("xyz"), 3)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+
+	b, err := ioutil.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte("ABCDEFGxy")) {
+		t.Errorf("Got '%s', expected 'ABCDEFGxy'", b)
+	}
+}
+
+func TestPaddedFileChunkSeekingSecondChunk(t *testing.T) {
+	tests := []struct {
+		name           string
+		offset         int64
+		whence         int
+		expectedPos    int64
+		expectedOutput []byte
+	}{
+		{"start", 1, io.SeekStart, 1, []byte("FG")},
+		{"current", 1, io.SeekCurrent, 2, []byte("GH")},
+		{"end", -3, io.SeekEnd, 1, []byte("FG")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile := CreateTMPFile(t, []byte("ABCDEFGH"))
+			chunk := SplitIntoPaddedChunks(tmpFile, 8, 2)[1]
+			_, err := chunk.Read(make([]byte, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pos, err := chunk.Seek(tt.offset, tt.whence)
+			if err != nil {
+				t.Fatalf("Unable to seek in chunk: %s", err)
+			}
+			if pos != tt.expectedPos {
+				t.Errorf("Got position %d, expected %d", pos, tt.expectedPos)
+			}
+			buf := make([]byte, 2)
+			_, err = chunk.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, tt.expectedOutput) {
+				t.Errorf("Got '%s', expected '%s'", buf, tt.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestPaddedFileChunkSeekErrorKeepsPosition(t *testing.T) {
+	tmpFile := CreateTMPFile(t, []byte("ABCDEFGH"))
+	chunk := SplitIntoPaddedChunks(tmpFile, 8, 2)[1]
+	_, err := chunk.Seek(3, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pos, err := chunk.Seek(2, io.SeekEnd)
+	if err == nil {
+		t.Fatal("Expected an error seeking past the end of the chunk")
+	}
+	if pos != 3 {
+		t.Errorf("Got position %d, expected 3", pos)
+	}
+}
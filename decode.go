@@ -0,0 +1,102 @@
+package rsutils
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Decode writes the original data, exactly md.Size bytes, to dst from any
+// md.DataShards of its shards. shards holds the data shards followed by the
+// parity shards, with nil for missing ones. Missing data shards are
+// reconstructed block by block while the shards are read; nothing is written
+// to the shards. Decode doesn't verify the shards against their hashes, so
+// corrupt shards have to be passed as nil; Join verifies them first.
+// If reconstruction fails, part of the data may already have been written to
+// dst.
+func Decode(shards []io.Reader, md *Metadata, dst io.Writer, opts ...Option) error {
+	o := newOptions(opts)
+	defer observeLatency(o.metrics, OpDecode, time.Now())
+	if len(shards) != md.DataShards+md.ParityShards {
+		return fmt.Errorf("Cannot decode shards: need %d shards, got %d", md.DataShards+md.ParityShards, len(shards))
+	}
+	return decode(shards, md, dst, o)
+}
+
+func decode(shards []io.Reader, md *Metadata, dst io.Writer, o options) error {
+	present := 0
+	missingData := false
+	for i, shard := range shards {
+		if shard != nil {
+			present++
+		} else if i < md.DataShards {
+			missingData = true
+		}
+	}
+	if present < md.DataShards {
+		return fmt.Errorf("Cannot decode shards: only %d shards present, need %d", present, md.DataShards)
+	}
+	if md.Size == 0 {
+		return nil
+	}
+	if !missingData {
+		return copyData(dst, io.MultiReader(shards[:md.DataShards]...), md.Size)
+	}
+
+	encoder, err := o.newDecoder(md)
+	if err != nil {
+		return fmt.Errorf("Cannot decode shards: %s", err)
+	}
+	// All shards are read in lockstep, but the data has to come out one data
+	// shard after the other: the first data shard goes straight to dst, the
+	// others are spooled to temporary files until it's done.
+	valid := make([]io.Reader, len(shards))
+	copy(valid, shards)
+	fill := make([]io.Writer, len(shards))
+	spools := make([]io.Reader, 0, md.DataShards-1)
+	for i := 0; i < md.DataShards; i++ {
+		var out io.Writer = dst
+		if i > 0 {
+			spool, err := ioutil.TempFile("", "rsutils_decode")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(spool.Name())
+			defer spool.Close()
+			spools = append(spools, spool)
+			out = spool
+		}
+		if shards[i] != nil {
+			valid[i] = io.TeeReader(shards[i], out)
+		} else {
+			fill[i] = out
+		}
+	}
+
+	err = encoder.Reconstruct(valid, fill)
+	if err != nil {
+		return fmt.Errorf("Error reconstructing data: %s", err)
+	}
+	for i, spool := range spools {
+		_, err = spool.(io.Seeker).Seek(0, io.SeekStart)
+		if err != nil {
+			return fmt.Errorf("Error rewinding shard %d: %s", i+1, err)
+		}
+	}
+	chunkSize := paddedChunkSize(md.Size, md.DataShards)
+	return copyData(dst, io.MultiReader(spools...), md.Size-chunkSize)
+}
+
+// copyData copies exactly size bytes of data from src to dst.
+func copyData(dst io.Writer, src io.Reader, size int64) error {
+	_, err := io.CopyN(dst, src, size)
+	if err == io.EOF {
+		return fmt.Errorf("Error decoding data: shards hold less than %d bytes", size)
+	}
+	if err != nil {
+		return fmt.Errorf("Error decoding data: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package rsutils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// readOnly hides everything but Read, so Decode can't seek or write.
+type readOnly struct {
+	r io.Reader
+}
+
+func (r readOnly) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func TestDecodeFromAnyShards(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		contents      []byte
+		dataShards    int
+		parityShards  int
+		missingShards []int
+	}{
+		{original, 3, 2, nil},
+		{original, 3, 2, []int{3, 4}},
+		{original, 3, 2, []int{0}},
+		{original, 3, 2, []int{1, 2}},
+		{original, 3, 2, []int{0, 4}},
+		{original, 4, 3, []int{0, 1, 3}},
+		{original, 1, 2, []int{0, 1}},
+		{[]byte("AB"), 3, 1, []int{0}},
+		{[]byte("ABCDEFG"), 3, 1, []int{2}},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%d bytes %d+%d missing %v", len(tt.contents), tt.dataShards, tt.parityShards, tt.missingShards)
+		t.Run(name, func(t *testing.T) {
+			shardContents, md := splitBytes(t, tt.contents, tt.dataShards, tt.parityShards)
+			shards := make([]io.Reader, len(shardContents))
+			for i := range shardContents {
+				shards[i] = readOnly{bytes.NewReader(shardContents[i])}
+			}
+			for _, i := range tt.missingShards {
+				shards[i] = nil
+			}
+
+			var decoded bytes.Buffer
+			err := Decode(shards, md, &decoded, WithStreamBlockSize(64))
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			if !bytes.Equal(decoded.Bytes(), tt.contents) {
+				t.Errorf("Got %d bytes:\n%s\nexpected %d bytes:\n%s", decoded.Len(), decoded.Bytes(), len(tt.contents), tt.contents)
+			}
+		})
+	}
+}
+
+func TestDecodeTooFewShards(t *testing.T) {
+	shardContents, md := splitBytes(t, []byte("ABCDEFGH"), 2, 1)
+	shards := []io.Reader{bytes.NewReader(shardContents[0]), nil, nil}
+
+	expectedErrMsg := "Cannot decode shards: only 1 shards present, need 2"
+	err := Decode(shards, md, ioutil.Discard)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
+
+func TestDecodeShortShards(t *testing.T) {
+	shardContents, md := splitBytes(t, []byte("ABCDEFGH"), 2, 1)
+	shards := []io.Reader{bytes.NewReader(shardContents[0]), bytes.NewReader(shardContents[1][:2]), nil}
+
+	expectedErrMsg := "Error decoding data: shards hold less than 8 bytes"
+	err := Decode(shards, md, ioutil.Discard)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
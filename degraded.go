@@ -0,0 +1,115 @@
+package rsutils
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// DegradedReads returns the number of reads that returned data rebuilt in
+// memory because the data shards holding it are corrupt. It is only ever
+// non-zero with WithDegradedReads, or when data shards could only be repaired
+// to the FallbackDestination.
+func (f *FileDecoder) DegradedReads() int64 {
+	return atomic.LoadInt64(&f.degradedReads)
+}
+
+// degradedReadAt reads len(p) bytes of data starting at offset off like
+// ReadAt, but rebuilds the bytes held by the corrupt data shards from the
+// other shards in memory rather than repairing them. f.repairMu must be held
+// for reading.
+func (f *FileDecoder) degradedReadAt(p []byte, off int64, corruptShards []*CorruptShard) (int, error) {
+	if len(corruptShards) > f.md.ParityShards {
+		return 0, fmt.Errorf("Cannot read data: %d shards corrupt, only have %d parity shards", len(corruptShards), f.md.ParityShards)
+	}
+	err := f.opts.checkMatrix(f.md)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot read data: %s", err)
+	}
+	encoder, err := newBlockEncoder(f.md)
+	if err != nil {
+		return 0, fmt.Errorf("Cannot read data: %s", err)
+	}
+	corrupt := make(map[int]bool)
+	for _, index := range shardIndexes(corruptShards) {
+		corrupt[index] = true
+	}
+
+	end := off + int64(len(p))
+	if end > f.md.Size {
+		end = f.md.Size
+	}
+	chunkSize := paddedChunkSize(f.md.Size, f.md.DataShards)
+	n := 0
+	rebuilt := false
+	for pos := off; pos < end; {
+		shard := int(pos / chunkSize)
+		shardOff := pos % chunkSize
+		length := chunkSize - shardOff
+		if length > end-pos {
+			length = end - pos
+		}
+		buf := p[n : n+int(length)]
+		if corrupt[shard] {
+			err = f.rebuildShardRange(encoder, buf, shard, shardOff, corrupt)
+			rebuilt = true
+		} else {
+			_, err = f.data.ReadAt(buf, pos)
+		}
+		if err != nil {
+			return n, err
+		}
+		n += len(buf)
+		pos += length
+	}
+	if rebuilt {
+		atomic.AddInt64(&f.degradedReads, 1)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// rebuildShardRange reconstructs len(dst) bytes of the data shard with the
+// given index, starting at offset off within the shard, into dst. It reads the
+// same range of DataShards intact shards a stripe of blockSize bytes at a
+// time, so memory use doesn't grow with the size of the range.
+func (f *FileDecoder) rebuildShardRange(encoder reedsolomon.Encoder, dst []byte, index int, off int64, corrupt map[int]bool) error {
+	chunks := SplitIntoPaddedChunks(f.data, f.md.Size, f.md.DataShards)
+	blockSize := f.opts.blockSize()
+	for done := 0; done < len(dst); {
+		size := len(dst) - done
+		if size > blockSize {
+			size = blockSize
+		}
+		stripeOff := off + int64(done)
+		shards := make([][]byte, f.md.DataShards+f.md.ParityShards)
+		survivors := 0
+		for i := range shards {
+			if corrupt[i] || survivors == f.md.DataShards {
+				continue
+			}
+			shards[i] = make([]byte, size)
+			var err error
+			if i < f.md.DataShards {
+				_, err = chunks[i].ReadAt(shards[i], stripeOff)
+			} else {
+				_, err = f.parityFiles[i-f.md.DataShards].ReadAt(shards[i], stripeOff)
+			}
+			if err != nil {
+				return fmt.Errorf("Error reading shard %d: %s", i, err)
+			}
+			survivors++
+		}
+		err := encoder.ReconstructData(shards)
+		if err != nil {
+			return fmt.Errorf("Error reconstructing data: %s", err)
+		}
+		copy(dst[done:], shards[index])
+		done += size
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package rsutils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// openReadOnly encodes original into dataShards data shards and 2 parity
+// shards, flips a byte in each of the shards corruptShards, and opens the
+// files read-only for degraded reads.
+func openReadOnly(t *testing.T, original []byte, dataShards int, corruptShards []int) (*FileDecoder, *os.File) {
+	dataFile := CreateTMPFile(t, original)
+	md, parityFiles := encodeTmp(t, dataFile, dataShards, 2)
+	chunkSize := paddedChunkSize(md.Size, md.DataShards)
+	for _, i := range corruptShards {
+		if i < md.DataShards {
+			flipByte(t, dataFile, int64(i)*chunkSize+1)
+		} else {
+			flipByte(t, parityFiles[i-md.DataShards], 1)
+		}
+	}
+
+	readOnlyFiles := make([]*os.File, 0, len(parityFiles)+1)
+	for _, f := range append([]*os.File{dataFile}, parityFiles...) {
+		readOnly, err := os.Open(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { readOnly.Close() })
+		readOnlyFiles = append(readOnlyFiles, readOnly)
+	}
+	decoder, err := Open(readOnlyFiles[0], readOnlyFiles[1:], md, WithDegradedReads(), WithStreamBlockSize(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoder, dataFile
+}
+
+func TestFileDecoderDegradedRead(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder, dataFile := openReadOnly(t, original, 3, []int{1, 2})
+	corrupted, err := ioutil.ReadFile(dataFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadAll(decoder)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(contents, original) {
+		t.Errorf("Got %d bytes:\n%s\nexpected %d bytes:\n%s", len(contents), contents, len(original), original)
+	}
+	if decoder.DegradedReads() == 0 {
+		t.Errorf("Expected degraded reads to be counted")
+	}
+
+	chunkSize := paddedChunkSize(int64(len(original)), 3)
+	buf := make([]byte, 20)
+	n, err := decoder.ReadAt(buf, 2*chunkSize-10)
+	if err != nil || n != len(buf) {
+		t.Fatalf("Got %d bytes and error %v, expected %d bytes and nil", n, err, len(buf))
+	}
+	if expected := original[2*chunkSize-10 : 2*chunkSize+10]; !bytes.Equal(buf, expected) {
+		t.Errorf("Got '%s', expected '%s'", buf, expected)
+	}
+
+	onDisk, err := ioutil.ReadFile(dataFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(onDisk, corrupted) {
+		t.Errorf("Expected the data file to be left as it was")
+	}
+}
+
+func TestFileDecoderDegradedReadTooManyCorruptShards(t *testing.T) {
+	decoder, _ := openReadOnly(t, []byte("ABCDEFGHIJKL"), 3, []int{0, 1, 4})
+
+	expectedErrMsg := "Cannot read data: 3 shards corrupt, only have 2 parity shards"
+	_, err := decoder.ReadAt(make([]byte, 4), 0)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+	if decoder.DegradedReads() != 0 {
+		t.Errorf("Got %d degraded reads, expected 0", decoder.DegradedReads())
+	}
+}
+
+func TestFileDecoderDegradedReadModificationPolicy(t *testing.T) {
+	original := []byte("ABCDEFGHIJKL")
+	corrupted := []byte("ABCDEFXHIJKL")
+	edited := []byte("abcdefghijkl")
+
+	tests := []struct {
+		name             string
+		policy           ModificationPolicy
+		contents         []byte
+		expectedErr      error
+		expectedContents []byte
+	}{
+		{"repair rebuilds corruption", RepairModifications, corrupted, nil, original},
+		{"refuse", RefuseModifications, corrupted, ErrDataModified, nil},
+		{"repair unless edited rebuilds corruption", RepairUnlessEdited, corrupted, nil, original},
+		{"repair unless edited refuses likely edit", RepairUnlessEdited, edited, ErrDataModified, nil},
+		{"reencode", ReencodeModifications, edited, nil, edited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataFile := CreateTMPFile(t, original)
+			md, parityFiles := encodeTmp(t, dataFile, 3, 2)
+			_, err := dataFile.WriteAt(tt.contents, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder, err := Open(dataFile, parityFiles, md, WithDegradedReads(), WithModificationPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, len(original))
+			_, err = decoder.ReadAt(buf, 0)
+			if err != tt.expectedErr {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if tt.expectedContents != nil && !bytes.Equal(buf, tt.expectedContents) {
+				t.Errorf("Got '%s', expected '%s'", buf, tt.expectedContents)
+			}
+			contents, err := ioutil.ReadFile(dataFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents, tt.contents) {
+				t.Errorf("Expected the data file to be left as '%s', got '%s'", tt.contents, contents)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package rsutils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// ErrShardRelocated is returned by repairs that could only write some of the
+// repaired shards to the FallbackDestination, because their original location
+// couldn't be written. Those shards are still corrupt and are reported as such
+// by later checks, but they are only written to the fallback destination once.
+var ErrShardRelocated = errors.New("Repaired shards could only be written to the fallback destination")
+
+// FallbackDestination returns where to write the repaired shard with the given
+// index when its original location can't be written, for example because the
+// disk sector holding it has failed. Shards are indexed data shards first,
+// then parity shards, the same as Metadata.Hashes. It may be called again for
+// the same shard, e.g. when a repair left unfinished is resumed, and every
+// call should replace what the previous one wrote.
+type FallbackDestination func(shard int) (io.Writer, error)
+
+// commitShard writes the repaired shard with the given index from src through
+// write, which overwrites the original shard. If that fails and a
+// FallbackDestination is set, src is written to the fallback instead, unless
+// relocated shows it already was, and ErrShardRelocated is returned. relocated
+// may be nil.
+func (o options) commitShard(index int, src io.ReadSeeker, relocated relocatedShards, write func(src io.Reader) error) error {
+	err := write(src)
+	if err == nil {
+		delete(relocated, index)
+		return nil
+	}
+	if o.fallback == nil {
+		return fmt.Errorf("Error writing repaired shard %d: %s", index, err)
+	}
+	if !relocated[index] {
+		fallbackErr := writeFallback(o.fallback, index, src)
+		if fallbackErr != nil {
+			return fmt.Errorf("Error writing repaired shard %d: %s; writing it to the fallback destination failed too: %s", index, err, fallbackErr)
+		}
+		if relocated != nil {
+			relocated[index] = true
+		}
+	}
+	return ErrShardRelocated
+}
+
+func writeFallback(fallback FallbackDestination, index int, src io.ReadSeeker) error {
+	_, err := src.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	w, err := fallback(index)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+// relocatedShards records the shards whose repaired contents were written to
+// the FallbackDestination, and which are therefore still corrupt.
+type relocatedShards map[int]bool
+
+// covers reports whether every shard in corruptShards was relocated.
+func (r relocatedShards) covers(corruptShards []*CorruptShard) bool {
+	for _, corruptShard := range corruptShards {
+		if !r[corruptShard.index] {
+			return false
+		}
+	}
+	return true
+}
+
+// corruptShards returns the relocated shards in index order.
+func (r relocatedShards) corruptShards() []*CorruptShard {
+	indexes := make([]int, 0, len(r))
+	for index := range r {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	corruptShards := make([]*CorruptShard, len(indexes))
+	for i, index := range indexes {
+		corruptShards[i] = &CorruptShard{index: index}
+	}
+	return corruptShards
+}
+
+// repairFiles holds the temporary files shards are reconstructed into before
+// they are committed.
+type repairFiles map[int]*os.File
+
+// newRepairFiles creates a temporary file for each shard in indexes.
+func newRepairFiles(indexes []int) (repairFiles, error) {
+	files := make(repairFiles, len(indexes))
+	for _, index := range indexes {
+		tempFile, err := ioutil.TempFile("", "rsutils_repair")
+		if err != nil {
+			files.remove()
+			return nil, fmt.Errorf("Error creating repair file: %s", err)
+		}
+		files[index] = tempFile
+	}
+	return files, nil
+}
+
+func (files repairFiles) remove() {
+	for _, tempFile := range files {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+	}
+}
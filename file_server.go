@@ -0,0 +1,174 @@
+package rsutils
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Prefixes of the endpoints of HealthHandler, relative to where it is mounted.
+const (
+	VerifyPathPrefix = "/verify/"
+	RepairPathPrefix = "/repair/"
+)
+
+// HealthReport is the JSON body returned by the endpoints of HealthHandler.
+type HealthReport struct {
+	// Path is the URL path of the file, relative to the root of the server.
+	Path         string
+	Size         int64
+	DataShards   int
+	ParityShards int
+	// CorruptShards are the indexes of the shards found corrupt, data
+	// shards first.
+	CorruptShards []int
+	// Healthy is true if no shard was corrupt, or all of them were repaired.
+	Healthy bool
+	// Repaired is true if corrupt shards were found and repaired.
+	Repaired bool
+	// Error describes why the file couldn't be verified or repaired.
+	Error string `json:",omitempty"`
+}
+
+// fileRoot maps URL paths to the files under root that were encoded with
+// EncodeFile and opens them with opts.
+type fileRoot struct {
+	root string
+	opts []Option
+}
+
+// filePath maps a URL path to the path of a file under root. The path is
+// cleaned first, so it can't escape root.
+func (fr fileRoot) filePath(urlPath string) string {
+	return filepath.Join(fr.root, filepath.FromSlash(path.Clean("/"+urlPath)))
+}
+
+// open opens the file at the URL path, writing an error response and
+// returning nil if it can't be opened.
+func (fr fileRoot) open(w http.ResponseWriter, r *http.Request, urlPath string) (*FileDecoder, os.FileInfo) {
+	filePath := fr.filePath(urlPath)
+	fi, err := os.Stat(filePath)
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return nil, nil
+	}
+	if _, err := os.Stat(MetadataFilePath(filePath)); err != nil {
+		http.NotFound(w, r)
+		return nil, nil
+	}
+	decoder, err := OpenFile(filePath, fr.opts...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil
+	}
+	return decoder, fi
+}
+
+// FileServer is an http.Handler serving the files under a root directory that
+// were encoded with EncodeFile. URL paths are mapped to file paths like
+// http.FileServer does; files are opened with OpenFile for every request and
+// served with http.ServeContent, so Range requests only verify, and if need
+// be repair, the shards holding the requested bytes. Use HealthHandler to
+// verify and repair whole files.
+type FileServer struct {
+	fileRoot
+}
+
+// NewFileServer returns a FileServer serving the files under root. The options
+// are passed to OpenFile for every request.
+func NewFileServer(root string, opts ...Option) *FileServer {
+	return &FileServer{fileRoot{root: root, opts: opts}}
+}
+
+func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serveFile(w, r)
+}
+
+func (s *FileServer) serveFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	decoder, fi := s.open(w, r, r.URL.Path)
+	if decoder == nil {
+		return
+	}
+	defer decoder.Close()
+	// Headers are sent before the data is read, so if the requested range
+	// turns out to be corrupt beyond repair the response is cut short.
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), decoder)
+}
+
+// HealthHandler is an http.Handler verifying and repairing the files under a
+// root directory that were encoded with EncodeFile. POST requests to
+// VerifyPathPrefix and RepairPathPrefix followed by the path of a file run
+// FileDecoder.CheckHealth or FileDecoder.Repair on it and respond with a JSON
+// HealthReport. Repairs write to the files, so mount it apart from FileServer,
+// behind whatever access control the server uses:
+//
+//	http.Handle("/_rsutils/", requireAdmin(http.StripPrefix("/_rsutils", rsutils.NewHealthHandler(root))))
+type HealthHandler struct {
+	fileRoot
+}
+
+// NewHealthHandler returns a HealthHandler for the files under root. The
+// options are passed to OpenFile for every request.
+func NewHealthHandler(root string, opts ...Option) *HealthHandler {
+	return &HealthHandler{fileRoot{root: root, opts: opts}}
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, VerifyPathPrefix):
+		h.serveHealth(w, r, strings.TrimPrefix(r.URL.Path, VerifyPathPrefix), false)
+	case strings.HasPrefix(r.URL.Path, RepairPathPrefix):
+		h.serveHealth(w, r, strings.TrimPrefix(r.URL.Path, RepairPathPrefix), true)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *HealthHandler) serveHealth(w http.ResponseWriter, r *http.Request, urlPath string, repair bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	decoder, _ := h.open(w, r, urlPath)
+	if decoder == nil {
+		return
+	}
+	defer decoder.Close()
+
+	report := &HealthReport{
+		Path:         path.Clean("/" + urlPath),
+		Size:         decoder.md.Size,
+		DataShards:   decoder.md.DataShards,
+		ParityShards: decoder.md.ParityShards,
+	}
+	status := http.StatusOK
+	var corruptShards []int
+	var err error
+	if repair {
+		// Repairing verifies every shard first, so there's no need to
+		// verify them separately.
+		corruptShards, err = decoder.repairAll()
+		report.Repaired = err == nil && len(corruptShards) > 0
+	} else {
+		corruptShards, err = decoder.findCorruptShards()
+	}
+	report.CorruptShards = corruptShards
+	report.Healthy = err == nil && (len(corruptShards) == 0 || report.Repaired)
+	if err != nil {
+		report.Error = err.Error()
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
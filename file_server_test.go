@@ -0,0 +1,203 @@
+package rsutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileServerServesFile(t *testing.T) {
+	path, original := encodeTestFile(t, 3, 2)
+	corruptFileAt(t, path, 10)
+	server := httptest.NewServer(NewFileServer(filepath.Dir(path)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Got status %d, expected %d", resp.StatusCode, http.StatusOK)
+	}
+	if !bytes.Equal(body, original) {
+		t.Errorf("Got body:\n%s\nexpected:\n%s", body, original)
+	}
+}
+
+func TestFileServerServesRange(t *testing.T) {
+	path, original := encodeTestFile(t, 3, 2)
+	server := httptest.NewServer(NewFileServer(filepath.Dir(path)))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=5-14")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Got status %d, expected %d", resp.StatusCode, http.StatusPartialContent)
+	}
+	if !bytes.Equal(body, original[5:15]) {
+		t.Errorf("Got body '%s', expected '%s'", body, original[5:15])
+	}
+}
+
+func TestFileServerNotFound(t *testing.T) {
+	path, _ := encodeTestFile(t, 3, 2)
+	server := httptest.NewServer(NewFileServer(filepath.Dir(path)))
+	defer server.Close()
+
+	tests := []struct {
+		method  string
+		urlPath string
+	}{
+		{http.MethodGet, "/missing"},
+		{http.MethodGet, "/data.rsmeta"},
+		{http.MethodGet, "/"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, server.URL+tt.urlPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Got status %d for %s %s, expected %d", resp.StatusCode, tt.method, tt.urlPath, http.StatusNotFound)
+		}
+	}
+}
+
+func TestFileServerPathStaysInRoot(t *testing.T) {
+	root := t.TempDir()
+	server := NewFileServer(root)
+	expected := filepath.Join(root, "etc", "passwd")
+	for _, urlPath := range []string{"/../etc/passwd", "/a/../../etc/passwd", "../etc/passwd"} {
+		if got := server.filePath(urlPath); got != expected {
+			t.Errorf("Got '%s' for '%s', expected '%s'", got, urlPath, expected)
+		}
+	}
+}
+
+func TestHealthHandlerNotFound(t *testing.T) {
+	path, _ := encodeTestFile(t, 3, 2)
+	server := httptest.NewServer(NewHealthHandler(filepath.Dir(path)))
+	defer server.Close()
+
+	for _, urlPath := range []string{VerifyPathPrefix + "missing", RepairPathPrefix + "data.rsmeta", "/data"} {
+		resp, err := http.Post(server.URL+urlPath, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Got status %d for %s, expected %d", resp.StatusCode, urlPath, http.StatusNotFound)
+		}
+	}
+}
+
+func TestFileServerDoesNotVerifyOrRepair(t *testing.T) {
+	path, _ := encodeTestFile(t, 3, 2)
+	server := httptest.NewServer(NewFileServer(filepath.Dir(path)))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+RepairPathPrefix+"data", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Errorf("Expected the file server not to serve the repair endpoint")
+	}
+}
+
+func TestHealthHandlerVerifyAndRepair(t *testing.T) {
+	path, original := encodeTestFile(t, 3, 2)
+	corruptFileAt(t, path, 10)
+	corruptFileAt(t, ParityFilePath(path, 1), 0)
+	metrics := newRecordingMetrics()
+	server := httptest.NewServer(NewHealthHandler(filepath.Dir(path), WithMetrics(metrics)))
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		prefix         string
+		expectedReport HealthReport
+	}{
+		{"verify corrupt", VerifyPathPrefix, HealthReport{CorruptShards: []int{0, 4}}},
+		{"repair", RepairPathPrefix, HealthReport{CorruptShards: []int{0, 4}, Healthy: true, Repaired: true}},
+		{"verify repaired", VerifyPathPrefix, HealthReport{CorruptShards: []int{}, Healthy: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+tt.prefix+"data", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Got status %d, expected %d", resp.StatusCode, http.StatusOK)
+			}
+			report := HealthReport{}
+			err = json.NewDecoder(resp.Body).Decode(&report)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := tt.expectedReport
+			expected.Path = "/data"
+			expected.Size = int64(len(original))
+			expected.DataShards = 3
+			expected.ParityShards = 2
+			if !reflect.DeepEqual(report, expected) {
+				t.Errorf("Got report %+v, expected %+v", report, expected)
+			}
+		})
+	}
+	// every shard is hashed once per request, not verified again before
+	// being repaired
+	shardsSize := 5 * paddedChunkSize(int64(len(original)), 3)
+	if metrics.bytesHashed[OpCheckHealth] != 2*shardsSize {
+		t.Errorf("Got %d bytes hashed verifying, expected %d", metrics.bytesHashed[OpCheckHealth], 2*shardsSize)
+	}
+	if metrics.bytesHashed[OpRepair] != shardsSize {
+		t.Errorf("Got %d bytes hashed repairing, expected %d", metrics.bytesHashed[OpRepair], shardsSize)
+	}
+}
+
+func TestHealthHandlerNeedsPost(t *testing.T) {
+	path, _ := encodeTestFile(t, 3, 2)
+	server := httptest.NewServer(NewHealthHandler(filepath.Dir(path)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + RepairPathPrefix + "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Got status %d, expected %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
@@ -7,14 +7,37 @@ import (
 	"hash"
 	"io"
 	"os"
+	"sync"
 	"time"
-
-	"github.com/klauspost/reedsolomon"
 )
 
 // Encode reads an *os.File f, divides it into dataShards shards, and outputs parity shard data to parityWriters.
 // It returns a Metadata object that contains information useful in reading or reconstructing the data again.
-func Encode(f *os.File, dataShards int, parityWriters []io.Writer) (*Metadata, error) {
+// With WithMetadataXattr, the Metadata is also stored alongside f so OpenPath can find it.
+func Encode(f *os.File, dataShards int, parityWriters []io.Writer, opts ...Option) (*Metadata, error) {
+	o := newOptions(opts)
+	defer observeLatency(o.metrics, OpEncode, time.Now())
+	md, err := encode(OpEncode, f, dataShards, nil, parityWriters, o)
+	if err != nil {
+		return nil, err
+	}
+	if o.metadataXattr {
+		err = storeMetadata(f, md, parityWriters)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return md, nil
+}
+
+// encode divides f into dataShards shards and outputs parity shard data to
+// parityWriters. If dataWriters is not nil, every data shard, padding
+// included, is also copied to the matching data writer. The hashed bytes are
+// reported to Metrics under op.
+func encode(op string, f *os.File, dataShards int, dataWriters, parityWriters []io.Writer, o options) (*Metadata, error) {
+	if o.localGroupSize > 0 {
+		return nil, fmt.Errorf("Cannot encode: local groups are only supported by ShardCreator")
+	}
 	parityShards := len(parityWriters)
 
 	fstat, err := f.Stat()
@@ -25,19 +48,25 @@ func Encode(f *os.File, dataShards int, parityWriters []io.Writer) (*Metadata, e
 	paddedChunks := SplitIntoPaddedChunks(f, fsize, dataShards)
 
 	hashers := make([]hash.Hash, dataShards+parityShards)
+	hashCounters := make([]*countingWriter, len(hashers))
 	for i := range hashers {
 		hashers[i] = sha256.New()
+		hashCounters[i] = &countingWriter{w: hashers[i]}
 	}
 	hashingReaders := make([]io.Reader, dataShards)
 	for i := range paddedChunks {
-		hashingReaders[i] = io.TeeReader(paddedChunks[i], hashers[i])
+		var hashWriter io.Writer = hashCounters[i]
+		if dataWriters != nil {
+			hashWriter = io.MultiWriter(dataWriters[i], hashCounters[i])
+		}
+		hashingReaders[i] = io.TeeReader(paddedChunks[i], hashWriter)
 	}
 	hashingWriters := make([]io.Writer, parityShards)
 	for i := range hashingWriters {
-		hashingWriters[i] = io.MultiWriter(parityWriters[i], hashers[dataShards+i])
+		hashingWriters[i] = io.MultiWriter(parityWriters[i], hashCounters[dataShards+i])
 	}
 
-	encoder, err := reedsolomon.NewStream(dataShards, parityShards)
+	encoder, err := o.newStreamEncoder(dataShards, parityShards, o.matrix)
 	if err != nil {
 		return nil, err
 	}
@@ -48,39 +77,121 @@ func Encode(f *os.File, dataShards int, parityWriters []io.Writer) (*Metadata, e
 	}
 
 	hashes := make([]string, dataShards+parityShards)
+	var bytesHashed int64
 	for i := range hashers {
 		hashes[i] = fmt.Sprintf("%x", hashers[i].Sum(nil))
+		bytesHashed += hashCounters[i].n
 	}
+	o.metrics.BytesHashed(op, bytesHashed)
 
 	return &Metadata{
 		Size:         fsize,
 		Hashes:       hashes,
 		DataShards:   dataShards,
 		ParityShards: parityShards,
+		Matrix:       o.matrix,
 	}, nil
 }
 
+// FileDecoder reads Reed-Solomon-protected data, verifying and repairing it as
+// needed. It is safe for concurrent use by multiple goroutines.
 type FileDecoder struct {
-	data         *os.File
-	parityFiles  []*os.File
-	md           *Metadata
-	dataMTime    time.Time
-	parityMTimes []time.Time
+	// degradedReads counts reads served by rebuilding data in memory. It is
+	// accessed atomically, so it comes first to keep it 64-bit aligned.
+	degradedReads int64
+
+	data        *os.File
+	parityFiles []*os.File
+	// md is updated in place when modified data is re-encoded, under both mu
+	// and repairMu, so it is only read while holding one of them.
+	md   *Metadata
+	opts options
+	// saveMetadata, if set, stores md where it was loaded from after it
+	// changed.
+	saveMetadata func() error
+	// relocated holds the shards repaired to the FallbackDestination. It is
+	// guarded by mu.
+	relocated relocatedShards
+
+	// mu serializes verification, so shards are verified once per change no
+	// matter how many goroutines read.
+	mu sync.Mutex
+
+	// repairMu is held for reading while data is read and for writing while
+	// shards are repaired.
+	repairMu sync.RWMutex
+
+	// offsetMu guards offset, the position of the next Read.
+	offsetMu sync.Mutex
+	offset   int64
 }
 
 // Open accepts a data file, some parityFiles, and a Metadata object. It returns a
 // FileDecoder object which can be used to Read the data back.
-func Open(data *os.File, parityFiles []*os.File, md *Metadata) (*FileDecoder, error) {
+// If a previous repair of these files was interrupted, Open finishes it, or
+// rolls it back when the reconstructed shards did not make it to disk intact.
+// A write through an Updater that was interrupted is rolled back.
+func Open(data *os.File, parityFiles []*os.File, md *Metadata, opts ...Option) (*FileDecoder, error) {
 	if len(parityFiles) != md.ParityShards {
 		return nil, fmt.Errorf("Cannot open encoded files: need %d parity shards, got %d", md.ParityShards, len(parityFiles))
 	}
-	return &FileDecoder{
-		data:         data,
-		parityFiles:  parityFiles,
-		md:           md,
-		dataMTime:    time.Time{},
-		parityMTimes: make([]time.Time, md.ParityShards),
-	}, nil
+	f := &FileDecoder{
+		data:        data,
+		parityFiles: parityFiles,
+		md:          md,
+		opts:        newOptions(opts),
+		relocated:   make(relocatedShards),
+	}
+	if len(md.LocalGroups) > 0 {
+		return nil, fmt.Errorf("Cannot open encoded files: local groups are only supported by ShardManager")
+	}
+	if err := f.opts.checkMatrix(md); err != nil {
+		return nil, fmt.Errorf("Cannot open encoded files: %s", err)
+	}
+
+	// Only take exclusive locks when there is something to recover, so
+	// opening files doesn't wait for everybody else verifying them.
+	if !journalExists(data) {
+		return f, nil
+	}
+	locks, err := f.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer locks.unlock()
+	// Shards the journal could only write to the fallback destination are
+	// recorded in f.relocated and rebuilt in memory when read.
+	err = recoverRepairJournal(data, parityFiles, md, f.opts, f.relocated)
+	if err != nil && err != ErrShardRelocated {
+		return nil, fmt.Errorf("Cannot recover interrupted repair: %s", err)
+	}
+	err = recoverUpdateJournal(data, parityFiles, md)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot recover interrupted write: %s", err)
+	}
+	return f, nil
+}
+
+// Close closes the data and parity files.
+func (f *FileDecoder) Close() error {
+	err := f.data.Close()
+	for _, parityFile := range f.parityFiles {
+		if closeErr := parityFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// lock takes advisory locks on the data and parity files: shared ones while
+// verifying, exclusive ones while repairing.
+func (f *FileDecoder) lock(exclusive bool) (*shardLocks, error) {
+	files := make([]interface{}, 0, len(f.parityFiles)+1)
+	files = append(files, f.data)
+	for _, parityFile := range f.parityFiles {
+		files = append(files, parityFile)
+	}
+	return lockShards(files, exclusive, f.opts.lockTimeout)
 }
 
 type CorruptShard struct {
@@ -88,143 +199,451 @@ type CorruptShard struct {
 	hash  string
 }
 
-func (f *FileDecoder) checkDataShardHealth() ([]*CorruptShard, error) {
-	var _stat os.FileInfo
+// checkDataShardHealth hashes the given data shards if the change detector
+// reports them as changed, or unconditionally if force is set. The hashed
+// bytes are reported to Metrics under op. A shard that can't be read is
+// reported as corrupt with an empty hash, so it is reconstructed like any
+// other corrupt shard. If the data file is no longer the size recorded in the
+// Metadata, the last data shard is always hashed and reported as corrupt, so
+// the ModificationPolicy also sees data appended past a full last shard.
+func (f *FileDecoder) checkDataShardHealth(op string, shards []int, force bool) ([]*CorruptShard, error) {
 	corruptShards := make([]*CorruptShard, 0)
-
-	_stat, err := f.data.Stat()
+	chunks := SplitIntoPaddedChunks(f.data, f.md.Size, f.md.DataShards)
+	// Taken before hashing, so a write made while the shards are hashed
+	// isn't recorded as verified.
+	fp, err := FingerprintFile(f.data)
 	if err != nil {
 		return nil, err
 	}
-	dataMTime := _stat.ModTime()
-	var checkDataShards bool = dataMTime != f.dataMTime
-	f.dataMTime = dataMTime
-	if checkDataShards {
-		for i, chunk := range SplitIntoPaddedChunks(f.data, f.md.Size, f.md.DataShards) {
-			hasher := sha256.New()
-			_, err := io.Copy(hasher, chunk)
+
+	lastShard := f.md.DataShards - 1
+	resized := fp.Size != f.md.Size
+	if resized {
+		checked := false
+		for _, i := range shards {
+			checked = checked || i == lastShard
+		}
+		if !checked {
+			shards = append(shards[:len(shards):len(shards)], lastShard)
+		}
+	}
+
+	for _, i := range shards {
+		if !force && !(resized && i == lastShard) {
+			changed, err := f.opts.detector.Changed(f.data, i)
 			if err != nil {
 				return nil, err
 			}
-			dShardHash := fmt.Sprintf("%x", hasher.Sum(nil))
-			desiredDShardHash := f.md.Hashes[i]
-
-			if dShardHash != desiredDShardHash {
-				corruptShards = append(corruptShards, &CorruptShard{index: i, hash: dShardHash})
+			if !changed {
+				continue
 			}
 		}
+		dShardHash, err := hashReader(chunks[i])
+		if err != nil {
+			corruptShards = append(corruptShards, &CorruptShard{index: i})
+			continue
+		}
+		f.opts.metrics.BytesHashed(op, chunks[i].limit-chunks[i].offset)
+		desiredDShardHash := f.md.Hashes[i]
+
+		if dShardHash != desiredDShardHash || (resized && i == lastShard) {
+			corruptShards = append(corruptShards, &CorruptShard{index: i, hash: dShardHash})
+			continue
+		}
+		err = f.opts.detector.MarkVerified(f.data, i, fp)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return corruptShards, nil
 }
 
-func (f *FileDecoder) checkParityShardsHealth() ([]*CorruptShard, error) {
+// checkParityShardsHealth hashes every parity file. Like data shards, parity
+// files that can't be read are reported as corrupt.
+func (f *FileDecoder) checkParityShardsHealth(op string) ([]*CorruptShard, error) {
 	corruptShards := make([]*CorruptShard, 0)
-	modifiedParityShards := make(map[int]time.Time, 0)
+	chunkSize := paddedChunkSize(f.md.Size, f.md.DataShards)
 
-	for i := range f.parityFiles {
-		_stat, err := f.parityFiles[i].Stat()
+	for i, parityFile := range f.parityFiles {
+		parityShardIdx := f.md.DataShards + i
+		pShardHash, err := hashReader(io.NewSectionReader(parityFile, 0, chunkSize))
 		if err != nil {
-			return nil, err
+			corruptShards = append(corruptShards, &CorruptShard{index: parityShardIdx})
+			continue
 		}
+		f.opts.metrics.BytesHashed(op, chunkSize)
+		desiredPShardHash := f.md.Hashes[parityShardIdx]
 
-		if parityMTime := _stat.ModTime(); parityMTime != f.parityMTimes[i] {
-			modifiedParityShards[i] = parityMTime
-			f.parityMTimes[i] = parityMTime
-		}
-	}
-	if len(modifiedParityShards) != 0 {
-		for i := range f.parityFiles {
-			hasher := sha256.New()
-			_, err := io.Copy(hasher, f.parityFiles[i])
-			defer f.parityFiles[i].Seek(0, os.SEEK_SET)
-			if err != nil {
-				return nil, err
-			}
-			parityShardIdx := f.md.DataShards + i
-			pShardHash := fmt.Sprintf("%x", hasher.Sum(nil))
-			desiredPShardHash := f.md.Hashes[parityShardIdx]
-
-			if pShardHash != desiredPShardHash {
-				corruptShards = append(corruptShards, &CorruptShard{index: parityShardIdx, hash: pShardHash})
-			}
+		if pShardHash != desiredPShardHash {
+			corruptShards = append(corruptShards, &CorruptShard{index: parityShardIdx, hash: pShardHash})
 		}
 	}
 	return corruptShards, nil
 }
 
-func (f *FileDecoder) checkShardHealth() ([]*CorruptShard, error) {
-	corruptDataShards, err := f.checkDataShardHealth()
+// checkShardHealth hashes every parity shard, and every data shard the change
+// detector reports as changed or all of them if force is set, and reports the
+// corrupt ones to Metrics and the Observer under op.
+func (f *FileDecoder) checkShardHealth(op string, force bool) ([]*CorruptShard, error) {
+	allDataShards := make([]int, f.md.DataShards)
+	for i := range allDataShards {
+		allDataShards[i] = i
+	}
+	corruptDataShards, err := f.checkDataShardHealth(op, allDataShards, force)
 	if err != nil {
 		return nil, err
 	}
-	corruptParityShards, err := f.checkParityShardsHealth()
+	corruptParityShards, err := f.checkParityShardsHealth(op)
 	if err != nil {
 		return nil, err
 	}
 
-	return append(corruptDataShards, corruptParityShards...), nil
+	corruptShards := append(corruptDataShards, corruptParityShards...)
+	if len(corruptShards) > 0 {
+		f.opts.metrics.CorruptShardsFound(op, len(corruptShards))
+		f.opts.observer.OnCorruptionDetected(f.shardEvent(op, corruptShards))
+	}
+	return corruptShards, nil
+}
+
+// shardsCovering returns the indexes of the data shards that hold the length
+// bytes of data starting at offset off. f.mu or repairMu must be held.
+func (f *FileDecoder) shardsCovering(off int64, length int) []int {
+	end := off + int64(length)
+	if end > f.md.Size {
+		end = f.md.Size
+	}
+	if off < 0 || off >= end {
+		return nil
+	}
+	chunkSize := paddedChunkSize(f.md.Size, f.md.DataShards)
+	shards := make([]int, 0)
+	for i := int(off / chunkSize); i <= int((end-1)/chunkSize); i++ {
+		shards = append(shards, i)
+	}
+	return shards
 }
 
+// attemptRepair reconstructs the corrupt shards into temporary files and only
+// then copies them over the originals, journaling the repair so a crash in the
+// middle of it can't leave both the data and the parity half-written.
+// Reconstructed shards are checked against their hashes before anything is
+// copied. If they don't match and there is parity to spare, the repair is
+// retried leaving out one intact shard at a time, in case one of them went bad
+// after it was verified.
 func (f *FileDecoder) attemptRepair(corruptShards []*CorruptShard) error {
 	if len(corruptShards) > len(f.parityFiles) {
 		return fmt.Errorf("Cannot repair data: %d shards corrupt, only have %d parity shards", len(corruptShards), len(f.parityFiles))
 	}
-	paddedChunks := SplitIntoPaddedChunks(f.data, f.md.Size, f.md.DataShards)
+	encoder, err := f.opts.newDecoder(f.md)
+	if err != nil {
+		return fmt.Errorf("Cannot repair data: %s", err)
+	}
 
-	shardCount := len(paddedChunks) + len(f.parityFiles)
-	shardReaders := make([]io.Reader, shardCount)
-	shardWriters := make([]io.Writer, shardCount)
+	corruptIndexes := shardIndexes(corruptShards)
+	survivors := survivingShards(f.md, corruptIndexes)
+	for _, excluded := range survivorExclusions(survivors, len(f.parityFiles)-len(corruptIndexes)) {
+		shardReaders := f.shardReaders()
+		for _, i := range corruptIndexes {
+			shardReaders[i] = nil
+		}
+		for _, i := range excluded {
+			shardReaders[i] = nil
+		}
+		err = journaledReconstruct(f.data, f.parityFiles, f.md, f.opts, f.relocated, corruptIndexes, func(fill []io.Writer) error {
+			return encoder.Reconstruct(shardReaders, fill)
+		})
+		if err != errReconstructionMismatch {
+			return err
+		}
+	}
+	return fmt.Errorf("Cannot repair data: %s", errReconstructionMismatch)
+}
 
+// shardReaders returns readers for every data and parity shard.
+func (f *FileDecoder) shardReaders() []io.Reader {
+	paddedChunks := SplitIntoPaddedChunks(f.data, f.md.Size, f.md.DataShards)
+	chunkSize := paddedChunkSize(f.md.Size, f.md.DataShards)
+
+	shardReaders := make([]io.Reader, len(paddedChunks)+len(f.parityFiles))
 	for i := range paddedChunks {
 		shardReaders[i] = paddedChunks[i]
 	}
 	for i := range f.parityFiles {
-		shardReaders[f.md.DataShards+i] = f.parityFiles[i]
+		shardReaders[f.md.DataShards+i] = io.NewSectionReader(f.parityFiles[i], 0, chunkSize)
 	}
+	return shardReaders
+}
 
-	var corruptIdx int
-	for _, corruptShard := range corruptShards {
-		corruptIdx = corruptShard.index
-		shardReaders[corruptIdx] = nil
+// Read attempts to read the Reed-Solomon-encoded data into []byte p.
+// It will check the integrity of the data shards covering the requested range first
+// and use the ChangeDetector set with WithChangeDetector to decide whether it needs
+// to check their integrity again in the case that the file changed between calling
+// Open and FileDecoder.Read. Parity shards are only checked when a data shard needs
+// repairing; use CheckHealth or Repair to check every shard.
+// If data shards are corrupted, calling Read will trigger an attempt to
+// repair the data. This will make the Read call take longer than when the data is
+// not corrupted. It may fail if the corruption is too extensive.
+// With WithDegradedReads, corrupt data is rebuilt in memory and returned
+// instead, leaving the files untouched.
+// Shards are verified under shared advisory locks and repaired under exclusive
+// ones, so several processes can read the same files safely.
+// Read keeps its own offset, starting at the beginning of the data; it does not
+// use or move the offset of the data file.
+// It returns the number of bytes read or an error.
+func (f *FileDecoder) Read(p []byte) (int, error) {
+	f.offsetMu.Lock()
+	defer f.offsetMu.Unlock()
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
 
-		if corruptIdx < f.md.DataShards {
-			shardWriters[corruptIdx] = paddedChunks[corruptIdx]
-		} else {
-			shardWriters[corruptIdx] = f.parityFiles[corruptIdx-f.md.DataShards]
+// ReadAt reads len(p) bytes of data starting at offset off, verifying and
+// repairing shards the same way Read does. It may be called concurrently.
+func (f *FileDecoder) ReadAt(p []byte, off int64) (int, error) {
+	defer observeLatency(f.opts.metrics, OpRead, time.Now())
+	corruptShards, err := f.verifyAndRepair(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	f.repairMu.RLock()
+	defer f.repairMu.RUnlock()
+	if off >= f.md.Size {
+		return 0, io.EOF
+	}
+	if len(corruptShards) > 0 {
+		return f.degradedReadAt(p, off, corruptShards)
+	}
+	// Don't read past the end of the data, even if the file is longer.
+	if remaining := f.md.Size - off; int64(len(p)) > remaining {
+		n, err := f.data.ReadAt(p[:remaining], off)
+		if err == nil {
+			err = io.EOF
 		}
+		return n, err
+	}
+	return f.data.ReadAt(p, off)
+}
+
+// Seek sets the offset of the next Read.
+func (f *FileDecoder) Seek(offset int64, whence int) (int64, error) {
+	f.offsetMu.Lock()
+	defer f.offsetMu.Unlock()
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = f.offset + offset
+	case io.SeekEnd:
+		f.repairMu.RLock()
+		position = f.md.Size + offset
+		f.repairMu.RUnlock()
+	default:
+		return f.offset, fmt.Errorf("Got %d, expected one of: io.SeekStart, io.SeekCurrent, io.SeekEnd", whence)
+	}
+	if position < 0 {
+		return f.offset, fmt.Errorf("Requested position %d is negative", position)
+	}
+	f.offset = position
+	return f.offset, nil
+}
+
+// verifyAndRepair verifies the data shards holding length bytes starting at
+// offset off and repairs the shards if any of them is corrupt. With
+// WithDegradedReads it returns the corrupt shards instead, to be rebuilt in
+// memory by degradedReadAt, and so it does for shards that could only be
+// repaired to the FallbackDestination.
+func (f *FileDecoder) verifyAndRepair(off int64, length int) ([]*CorruptShard, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	shards := f.shardsCovering(off, length)
+	if len(shards) == 0 {
+		return nil, nil
 	}
 
-	encoder, err := reedsolomon.NewStream(f.md.DataShards, f.md.ParityShards)
+	locks, err := f.lock(false)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	corruptShards, err := f.checkDataShardHealth(OpRead, shards, false)
+	if err != nil || len(corruptShards) == 0 {
+		locks.unlock()
+		return nil, err
+	}
+	if f.opts.degradedReads {
+		// The other shards are about to be used to rebuild the corrupt
+		// ones, so they have to be verified too.
+		corruptShards, err = f.checkShardHealth(OpRead, false)
+		locks.unlock()
+		if err != nil {
+			return nil, err
+		}
+		// Changed data is only rebuilt if the ModificationPolicy would
+		// have repaired it.
+		reencodeData, err := f.applyPolicy(corruptShards)
+		if err != nil {
+			return nil, err
+		}
+		if !reencodeData {
+			return corruptShards, nil
+		}
+		// Re-encoding writes the parity files, so it is done under
+		// exclusive locks like a repair.
+		_, err = f.repair(OpRead)
+		return nil, err
+	}
+	if f.relocated.covers(corruptShards) {
+		corruptShards, err = f.checkShardHealth(OpRead, false)
+		if err != nil || f.relocated.covers(corruptShards) {
+			locks.unlock()
+			return corruptShards, err
+		}
+	}
+	locks.unlock()
+	// Another process may have repaired the shards while we weren't holding
+	// any lock, so repair checks them all again.
+	_, err = f.repair(OpRead)
+	if err == ErrShardRelocated {
+		// Shards only repaired to the fallback destination are still
+		// corrupt, so their data is rebuilt in memory.
+		return f.relocated.corruptShards(), nil
+	}
+	return nil, err
+}
 
-	err = encoder.Reconstruct(shardReaders, shardWriters)
+// CheckHealth verifies every data and parity shard, regardless of the change
+// detector, without repairing anything. It returns nil if all shards are good.
+func (f *FileDecoder) CheckHealth() error {
+	corruptIndexes, err := f.findCorruptShards()
 	if err != nil {
 		return err
 	}
+	if len(corruptIndexes) > 0 {
+		return fmt.Errorf("Corrupted shards: %v", corruptIndexes)
+	}
 	return nil
 }
 
-// Read attempts to read the Reed-Solomon-encoded data into []byte p.
-// It will check the integrity of the data first and use file modified time to
-// keep track whether it needs to check the integrity again in the case that the file
-// changed between calling Open and FileEncoder.Read.
-// If data or parity shards are corrupted, calling Read will trigger an attempt to 
-// repair the data. This will make the Read call take longer than when the data is 
-// not corrupted. It may fail if the corruption is too extensive.
-// It returns the number of bytes read or an error.
-func (f *FileDecoder) Read(p []byte) (int, error) {
-	corruptShards, err := f.checkShardHealth()
+// findCorruptShards verifies every data and parity shard under shared locks and
+// returns the indexes of the corrupt ones.
+func (f *FileDecoder) findCorruptShards() ([]int, error) {
+	defer observeLatency(f.opts.metrics, OpCheckHealth, time.Now())
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	locks, err := f.lock(false)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	if len(corruptShards) != 0 {
-		err := f.attemptRepair(corruptShards)
-		if err != nil {
-			return 0, err
+	defer locks.unlock()
+	corruptShards, err := f.checkShardHealth(OpCheckHealth, true)
+	if err != nil {
+		return nil, fmt.Errorf("Error while checking shard integrity: %s", err)
+	}
+	return shardIndexes(corruptShards), nil
+}
+
+// Repair verifies every data and parity shard, regardless of the change
+// detector, and repairs the corrupt ones according to the ModificationPolicy.
+func (f *FileDecoder) Repair() error {
+	_, err := f.repairAll()
+	return err
+}
+
+// repairAll does what Repair does and also returns the indexes of the corrupt
+// shards it found.
+func (f *FileDecoder) repairAll() ([]int, error) {
+	defer observeLatency(f.opts.metrics, OpRepair, time.Now())
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.repair(OpRepair)
+}
+
+// repair checks and repairs all shards under exclusive locks, reporting to
+// Metrics and the Observer under op. It returns the indexes of the corrupt
+// shards it found. f.mu must be held.
+func (f *FileDecoder) repair(op string) ([]int, error) {
+	locks, err := f.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer locks.unlock()
+	corruptShards, err := f.checkShardHealth(op, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(corruptShards) == 0 {
+		return shardIndexes(corruptShards), nil
+	}
+	if f.relocated.covers(corruptShards) {
+		// Repairing them again would only write them to the fallback
+		// destination again.
+		return shardIndexes(corruptShards), ErrShardRelocated
+	}
+
+	f.repairMu.Lock()
+	defer f.repairMu.Unlock()
+	event := f.shardEvent(op, corruptShards)
+	f.opts.observer.OnRepairStarted(event)
+	err = f.resolveCorruption(corruptShards)
+	reportRepair(f.opts.metrics, op, err)
+	reportRepairEvent(f.opts.observer, event, err)
+	return shardIndexes(corruptShards), err
+}
+
+func (f *FileDecoder) shardEvent(op string, corruptShards []*CorruptShard) ShardEvent {
+	return newShardEvent(op, corruptShards, f.md, paddedChunkSize(f.md.Size, f.md.DataShards))
+}
+
+// resolveCorruption applies the ModificationPolicy to corrupt data shards and
+// repairs whatever is left to repair.
+func (f *FileDecoder) resolveCorruption(corruptShards []*CorruptShard) error {
+	reencodeData, err := f.applyPolicy(corruptShards)
+	if err != nil {
+		return err
+	}
+	if reencodeData {
+		err := reencode(f.data, f.parityFiles, f.md, f.opts, f.relocated)
+		if err != nil && err != ErrShardRelocated {
+			return err
 		}
+		if f.saveMetadata != nil {
+			if saveErr := f.saveMetadata(); saveErr != nil {
+				return saveErr
+			}
+		}
+		return err
 	}
+	return f.attemptRepair(corruptShards)
+}
 
-	return f.data.Read(p)
+// applyPolicy applies the ModificationPolicy to the corrupt data shards among
+// corruptShards. It returns ErrDataModified if the policy refuses the change,
+// and otherwise whether the data is to be re-encoded rather than repaired.
+func (f *FileDecoder) applyPolicy(corruptShards []*CorruptShard) (bool, error) {
+	dataChanged := false
+	for _, corruptShard := range corruptShards {
+		if corruptShard.index < f.md.DataShards {
+			dataChanged = true
+		}
+	}
+	if !dataChanged {
+		return false, nil
+	}
+	switch f.opts.policy {
+	case ReencodeModifications:
+		return true, nil
+	case RefuseModifications:
+		return false, ErrDataModified
+	case RepairUnlessEdited:
+		if looksLikeEdit(corruptShards, f.md) {
+			return false, ErrDataModified
+		}
+	}
+	return false, nil
 }
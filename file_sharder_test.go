@@ -2,9 +2,13 @@ package rsutils
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -167,3 +171,328 @@ func TestFileSharderUnevenInputE2E(t *testing.T) {
 		t.Errorf("Expected output\n%s\nBut got:\n%s", expectedContents, contents)
 	}
 }
+
+// The concurrency tests are most useful when run with the race detector:
+// go test -race
+func TestFileDecoderConcurrentReadAt(t *testing.T) {
+	dataInput := cloneFileTmp(t, getTestFile(t, "input4_corrupt")).(*os.File)
+	parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+	md := getMetadata()
+	expected, err := ioutil.ReadFile("testdata/input3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := Open(dataInput, []*os.File{parityInput}, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readSize := 101
+	var wg sync.WaitGroup
+	errs := make(chan error, int(md.Size)/readSize+1)
+	for off := 0; off < int(md.Size); off += readSize {
+		wg.Add(1)
+		go func(off int) {
+			defer wg.Done()
+			buf := make([]byte, readSize)
+			n, err := decoder.ReadAt(buf, int64(off))
+			if err != nil && err != io.EOF {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(buf[:n], expected[off:off+n]) {
+				errs <- fmt.Errorf("Wrong data at offset %d: got '%s'", off, buf[:n])
+			}
+		}(off)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestFileDecoderConcurrentRead(t *testing.T) {
+	dataInput := cloneFileTmp(t, getTestFile(t, "input3")).(*os.File)
+	parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+	md := getMetadata()
+
+	decoder, err := Open(dataInput, []*os.File{parityInput}, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	total := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 10)
+			for {
+				n, err := decoder.Read(buf)
+				mu.Lock()
+				total += n
+				mu.Unlock()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if total != int(md.Size) {
+		t.Errorf("Concurrent reads returned %d bytes in total, expected %d", total, md.Size)
+	}
+}
+
+// recordingDetector always verifies and records which shards were checked.
+type recordingDetector struct {
+	checked []int
+}
+
+func (d *recordingDetector) Changed(f *os.File, shard int) (bool, error) {
+	d.checked = append(d.checked, shard)
+	return true, nil
+}
+
+func (d *recordingDetector) MarkVerified(f *os.File, shard int, fp Fingerprint) error {
+	return nil
+}
+
+func TestFileDecoderVerifiesRequestedRangeOnly(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataFile := CreateTMPFile(t, original)
+	md, parityFiles := encodeTmp(t, dataFile, 3, 1)
+	chunkSize := int64(len(original)+2) / 3
+
+	// corrupt the last data shard and the parity shard, more than can be repaired
+	_, err = dataFile.WriteAt([]byte("XX"), 2*chunkSize+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parityFiles[0].WriteAt([]byte("XX"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	detector := &recordingDetector{}
+	decoder, err := Open(dataFile, parityFiles, md, WithChangeDetector(detector))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 10)
+	_, err = decoder.ReadAt(buf, chunkSize-5)
+	if err != nil {
+		t.Fatalf("Expected reading intact shards to succeed, got %s", err)
+	}
+	if !bytes.Equal(buf, original[chunkSize-5:chunkSize+5]) {
+		t.Errorf("Got '%s', expected '%s'", buf, original[chunkSize-5:chunkSize+5])
+	}
+	if fmt.Sprint(detector.checked) != "[0 1]" {
+		t.Errorf("Expected only shards [0 1] to be checked, got %v", detector.checked)
+	}
+
+	expectedErrMsg := "Cannot repair data: 2 shards corrupt, only have 1 parity shards"
+	_, err = decoder.ReadAt(buf, 2*chunkSize)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
+
+func TestFileDecoderRepairKeepsUnevenSize(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		dataShards int
+		grownBy    int
+	}{
+		{"2 data shards", 2, 0},
+		{"3 data shards", 3, 0},
+		{"5 data shards", 5, 0},
+		{"grown by an older repair", 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataFile := CreateTMPFile(t, original)
+			md, parityFiles := encodeTmp(t, dataFile, tt.dataShards, 2)
+			if md.Size%int64(tt.dataShards) == 0 {
+				t.Fatalf("Expected %d bytes not to split evenly into %d shards", md.Size, tt.dataShards)
+			}
+			// Padding zeros appended past the end don't change the hash of
+			// the last shard.
+			_, err = dataFile.WriteAt(make([]byte, tt.grownBy), md.Size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = dataFile.WriteAt([]byte{0xff, 0xfe}, md.Size-2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder, err := Open(dataFile, parityFiles, md)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = decoder.Repair()
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+
+			contents, err := ioutil.ReadFile(dataFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents, original) {
+				t.Errorf("Got %d bytes:\n%s\nexpected %d bytes:\n%s", len(contents), contents, len(original), original)
+			}
+			buf := make([]byte, md.Size+10)
+			n, err := decoder.ReadAt(buf, 0)
+			if err != io.EOF || n != int(md.Size) {
+				t.Errorf("Got %d bytes and error %v, expected %d bytes and EOF", n, err, md.Size)
+			}
+		})
+	}
+}
+
+// reopenParityFile opens the first parity file again with flag.
+func reopenParityFile(t *testing.T, parityFiles []*os.File, flag int) []*os.File {
+	parityFile, err := os.OpenFile(parityFiles[0].Name(), flag, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { parityFile.Close() })
+	return append([]*os.File{parityFile}, parityFiles[1:]...)
+}
+
+func TestFileDecoderRepairUnreadableParityFile(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDEFGHIJKL"))
+	md, parityFiles := encodeTmp(t, dataFile, 3, 2)
+	flipByte(t, parityFiles[0], 1)
+
+	decoder, err := Open(dataFile, reopenParityFile(t, parityFiles, os.O_WRONLY), md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = decoder.Repair()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	contents, err := ioutil.ReadFile(parityFiles[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := fmt.Sprintf("%x", sha256.Sum256(contents)); hash != md.Hashes[3] {
+		t.Errorf("Got hash %s for the repaired parity file, expected %s", hash, md.Hashes[3])
+	}
+}
+
+func TestFileDecoderRepairUnwritableParityFile(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDEFGHIJKL"))
+	md, parityFiles := encodeTmp(t, dataFile, 3, 2)
+	flipByte(t, parityFiles[0], 1)
+	readOnlyParityFiles := reopenParityFile(t, parityFiles, os.O_RDONLY)
+
+	decoder, err := Open(dataFile, readOnlyParityFiles, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedErrPrefix := "Error writing repaired shard 3: "
+	err = decoder.Repair()
+	if err == nil || !strings.HasPrefix(err.Error(), expectedErrPrefix) {
+		t.Errorf("Expected error starting with '%s', got '%v'", expectedErrPrefix, err)
+	}
+
+	var fallback bytes.Buffer
+	fallbackCalls := 0
+	// The shard goes to the fallback destination once; repairing again
+	// doesn't write it again.
+	decoder, err = Open(dataFile, readOnlyParityFiles, md, WithFallbackDestination(func(shard int) (io.Writer, error) {
+		fallbackCalls++
+		return &fallback, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = decoder.Repair()
+	if err != ErrShardRelocated {
+		t.Fatalf("Expected ErrShardRelocated, got %v", err)
+	}
+	err = decoder.Repair()
+	if err != ErrShardRelocated {
+		t.Fatalf("Expected ErrShardRelocated from the second Repair, got %v", err)
+	}
+	if fallbackCalls != 1 {
+		t.Errorf("Expected the fallback destination to be written once, got %d times", fallbackCalls)
+	}
+	if hash := fmt.Sprintf("%x", sha256.Sum256(fallback.Bytes())); hash != md.Hashes[3] {
+		t.Errorf("Got hash %s for the repaired parity shard, expected %s", hash, md.Hashes[3])
+	}
+}
+
+func TestFileDecoderReadRelocatedDataShard(t *testing.T) {
+	original := []byte("ABCDEFGHIJKL")
+	dataFile := CreateTMPFile(t, original)
+	md, parityFiles := encodeTmp(t, dataFile, 3, 2)
+	flipByte(t, dataFile, 5)
+	readOnlyData, err := os.Open(dataFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnlyData.Close()
+
+	fallbackCalls := 0
+	observer := &recordingObserver{}
+	decoder, err := Open(readOnlyData, parityFiles, md, WithObserver(observer), WithFallbackDestination(func(shard int) (io.Writer, error) {
+		fallbackCalls++
+		return ioutil.Discard, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		buf := make([]byte, len(original))
+		_, err = decoder.ReadAt(buf, 0)
+		if err != nil {
+			t.Fatalf("Expected nil error, got %s", err)
+		}
+		if !bytes.Equal(buf, original) {
+			t.Errorf("Got '%s', expected '%s'", buf, original)
+		}
+	}
+	if fallbackCalls != 1 {
+		t.Errorf("Expected the fallback destination to be written once, got %d times", fallbackCalls)
+	}
+	if decoder.DegradedReads() != 2 {
+		t.Errorf("Expected 2 reads rebuilt in memory, got %d", decoder.DegradedReads())
+	}
+	if observer.calls[2] != "failed" || observer.events[2].Err != ErrShardRelocated {
+		t.Errorf("Expected the repair to fail with ErrShardRelocated, got calls %v", observer.calls)
+	}
+	err = decoder.Repair()
+	if err != ErrShardRelocated {
+		t.Errorf("Expected ErrShardRelocated, got %v", err)
+	}
+	contents, err := ioutil.ReadFile(dataFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(contents, original) {
+		t.Errorf("Expected the data file to be left as it was")
+	}
+}
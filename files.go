@@ -0,0 +1,110 @@
+package rsutils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ParityFilePath returns the path of the parity file with the given index, in
+// the naming convention used by EncodeFile: "<path>.rs.0", "<path>.rs.1", etc.
+func ParityFilePath(path string, index int) string {
+	return fmt.Sprintf("%s.rs.%d", path, index)
+}
+
+// MetadataFilePath returns the path of the metadata file EncodeFile writes for
+// the data file at path: "<path>.rsmeta".
+func MetadataFilePath(path string) string {
+	return sidecarPath(path)
+}
+
+// EncodeFile encodes the file at path into dataShards data shards and
+// parityShards parity shards. The parity shards are written to sidecar files
+// named by ParityFilePath and the Metadata, as JSON, to MetadataFilePath.
+// Existing sidecar files are overwritten.
+func EncodeFile(path string, dataShards, parityShards int, opts ...Option) (*Metadata, error) {
+	data, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	parityFiles := make([]*os.File, 0, parityShards)
+	parityWriters := make([]io.Writer, 0, parityShards)
+	cleanup := func() {
+		for i, parityFile := range parityFiles {
+			parityFile.Close()
+			os.Remove(ParityFilePath(path, i))
+		}
+	}
+	for i := 0; i < parityShards; i++ {
+		parityFile, err := os.OpenFile(ParityFilePath(path, i), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		parityFiles = append(parityFiles, parityFile)
+		parityWriters = append(parityWriters, parityFile)
+	}
+
+	md, err := Encode(data, dataShards, parityWriters, opts...)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	stored, err := newStoredMetadata(data, md, parityWriters)
+	if err == nil {
+		err = writeSidecar(path, stored)
+	}
+	for _, parityFile := range parityFiles {
+		if syncErr := parityFile.Sync(); err == nil {
+			err = syncErr
+		}
+		if closeErr := parityFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// OpenFile opens the file at path, encoded by EncodeFile, together with its
+// parity and metadata sidecar files. The returned FileDecoder owns the files;
+// call Close when done with it.
+func OpenFile(path string, opts ...Option) (*FileDecoder, error) {
+	stored, err := readSidecar(path)
+	if err != nil {
+		return nil, err
+	}
+	// Discover the parity files by name; openStored resolves them relative
+	// to the directory of the data file.
+	for i := range stored.ParityFiles {
+		stored.ParityFiles[i] = filepath.Base(ParityFilePath(path, i))
+	}
+	return openStored(path, stored, writeSidecar, opts)
+}
+
+// VerifyFile checks every shard of the file at path, encoded by EncodeFile,
+// without repairing anything. It returns nil if all shards are good.
+func VerifyFile(path string, opts ...Option) error {
+	decoder, err := OpenFile(path, opts...)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+	return decoder.CheckHealth()
+}
+
+// RepairFile checks every shard of the file at path, encoded by EncodeFile, and
+// repairs the corrupt ones in place.
+func RepairFile(path string, opts ...Option) error {
+	decoder, err := OpenFile(path, opts...)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+	return decoder.Repair()
+}
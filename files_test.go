@@ -0,0 +1,115 @@
+package rsutils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encodeTestFile(t *testing.T, dataShards, parityShards int) (string, []byte) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "data")
+	err = ioutil.WriteFile(path, original, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = EncodeFile(path, dataShards, parityShards)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	return path, original
+}
+
+func corruptFileAt(t *testing.T, path string, offset int64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.WriteAt([]byte{0xff, 0xfe}, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeFileCreatesSidecars(t *testing.T) {
+	path, _ := encodeTestFile(t, 3, 2)
+
+	for _, sidecar := range []string{MetadataFilePath(path), ParityFilePath(path, 0), ParityFilePath(path, 1)} {
+		if _, err := os.Stat(sidecar); err != nil {
+			t.Errorf("Expected %s to exist, got %s", sidecar, err)
+		}
+	}
+	if _, err := os.Stat(ParityFilePath(path, 2)); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 parity files, got %v", err)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	path, original := encodeTestFile(t, 3, 2)
+	corruptFileAt(t, path, 10)
+
+	decoder, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	contents := make([]byte, len(original))
+	_, err = decoder.ReadAt(contents, 0)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(contents, original) {
+		t.Errorf("Got contents:\n%s\nexpected:\n%s", contents, original)
+	}
+}
+
+func TestVerifyAndRepairFile(t *testing.T) {
+	path, original := encodeTestFile(t, 3, 2)
+
+	err := VerifyFile(path)
+	if err != nil {
+		t.Errorf("Expected nil error, got %s", err)
+	}
+
+	corruptFileAt(t, path, 10)
+	corruptFileAt(t, ParityFilePath(path, 1), 0)
+	expectedErrMsg := "Corrupted shards: [0 4]"
+	err = VerifyFile(path)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+
+	err = RepairFile(path)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	err = VerifyFile(path)
+	if err != nil {
+		t.Errorf("Expected nil error after repair, got %s", err)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, original) {
+		t.Errorf("Got contents:\n%s\nexpected:\n%s", contents, original)
+	}
+}
+
+func TestOpenFileWithoutSidecars(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	err := ioutil.WriteFile(path, []byte("ABCD"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenFile(path)
+	if err == nil {
+		t.Errorf("Expected an error for a file without sidecars")
+	}
+}
@@ -0,0 +1,18 @@
+package rsutils
+
+import (
+	"os"
+	"syscall"
+)
+
+func statFingerprint(fi os.FileInfo) Fingerprint {
+	fp := Fingerprint{
+		Size:  fi.Size(),
+		MTime: fi.ModTime().UnixNano(),
+	}
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		fp.Inode = stat.Ino
+		fp.CTime = stat.Ctim.Nano()
+	}
+	return fp
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package rsutils
+
+import "os"
+
+// Inode numbers and change times are only read on Linux; elsewhere the
+// fingerprint falls back to size and modification time.
+func statFingerprint(fi os.FileInfo) Fingerprint {
+	return Fingerprint{
+		Size:  fi.Size(),
+		MTime: fi.ModTime().UnixNano(),
+	}
+}
@@ -0,0 +1,414 @@
+package rsutils
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// repairJournal records reconstructed shards that were written to temporary
+// files but may not have been copied over the corrupt originals yet. It is
+// stored next to the data file for as long as a repair is in progress so an
+// interrupted repair can be resumed or rolled back.
+type repairJournal struct {
+	Shards []journalEntry
+}
+
+type journalEntry struct {
+	// Index of the shard, data shards first, then parity shards.
+	Index int
+	// TempFile holds the reconstructed contents of the shard.
+	TempFile string
+	// Hash of TempFile, used to detect incomplete or damaged temp files.
+	Hash string
+}
+
+func journalPath(data *os.File) string {
+	return data.Name() + ".rsjournal"
+}
+
+// journalExists reports whether an interrupted repair or Updater write may have
+// left a journal next to data.
+func journalExists(data *os.File) bool {
+	for _, path := range []string{journalPath(data), updateJournalPath(data)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// shardTarget returns a writer positioned at the beginning of the shard with
+// the given index.
+func shardTarget(data *os.File, parityFiles []*os.File, md *Metadata, index int) io.Writer {
+	if index < md.DataShards {
+		return SplitIntoPaddedChunks(data, md.Size, md.DataShards)[index]
+	}
+	parityFile := parityFiles[index-md.DataShards]
+	chunkSize := paddedChunkSize(md.Size, md.DataShards)
+	return SplitIntoPaddedChunks(parityFile, chunkSize, 1)[0]
+}
+
+// journaledReconstruct reconstructs the shards listed in corruptIndexes into
+// temporary files, then commits them to the data and parity files through a
+// repair journal. If the process crashes after the journal is written, the
+// next call to recoverRepairJournal finishes the repair.
+// Nothing is committed unless every reconstructed shard matches its hash in
+// md; errReconstructionMismatch is returned otherwise. Shards written to the
+// FallbackDestination are recorded in relocated.
+func journaledReconstruct(data *os.File, parityFiles []*os.File, md *Metadata, o options, relocated relocatedShards, corruptIndexes []int, reconstruct func(fill []io.Writer) error) error {
+	journal := &repairJournal{Shards: make([]journalEntry, 0, len(corruptIndexes))}
+	for _, index := range corruptIndexes {
+		journal.Shards = append(journal.Shards, journalEntry{Index: index, TempFile: repairFilePath(data, index)})
+	}
+	// Journal the temp files before creating them, without hashes, so a
+	// crash while reconstructing leaves a journal recoverRepairJournal
+	// rolls back, removing them.
+	err := writeJournal(journalPath(data), journal)
+	if err != nil {
+		return err
+	}
+	tempFiles := make([]*os.File, 0, len(corruptIndexes))
+	defer func() {
+		for _, tempFile := range tempFiles {
+			tempFile.Close()
+		}
+	}()
+
+	fill := make([]io.Writer, md.DataShards+md.ParityShards)
+	hashWriters := make([]*hashingWriter, len(corruptIndexes))
+	for i, entry := range journal.Shards {
+		tempFile, err := os.OpenFile(entry.TempFile, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if err != nil {
+			rollbackJournal(data, journal)
+			return fmt.Errorf("Error creating repair file: %s", err)
+		}
+		tempFiles = append(tempFiles, tempFile)
+		hashWriters[i] = newHashingWriter(tempFile)
+		fill[entry.Index] = hashWriters[i]
+	}
+
+	err = reconstruct(fill)
+	if err != nil {
+		rollbackJournal(data, journal)
+		return err
+	}
+	for i, tempFile := range tempFiles {
+		err := tempFile.Sync()
+		if err != nil {
+			rollbackJournal(data, journal)
+			return fmt.Errorf("Error syncing repair file: %s", err)
+		}
+		journal.Shards[i].Hash = hashWriters[i].Hash()
+		if journal.Shards[i].Hash != md.Hashes[journal.Shards[i].Index] {
+			rollbackJournal(data, journal)
+			return errReconstructionMismatch
+		}
+	}
+
+	err = writeJournal(journalPath(data), journal)
+	if err != nil {
+		rollbackJournal(data, journal)
+		return err
+	}
+	return applyJournal(data, parityFiles, md, o, relocated, journal)
+}
+
+// repairFilePath returns the path of the temp file the shard with the given
+// index is reconstructed into.
+func repairFilePath(data *os.File, index int) string {
+	return fmt.Sprintf("%s.rsrepair.%d", data.Name(), index)
+}
+
+// recoverRepairJournal looks for a journal left behind by an interrupted
+// repair. If every reconstructed shard is intact and still matches its hash
+// in md the repair is finished, otherwise it is rolled back by discarding the
+// journal and its temp files, leaving the shards to be checked and repaired
+// again. A journal written before the shards were reconstructed has no hashes
+// and is always rolled back, and so is one that can't be committed, e.g.
+// because a shard is read-only.
+func recoverRepairJournal(data *os.File, parityFiles []*os.File, md *Metadata, o options, relocated relocatedShards) error {
+	contents, err := ioutil.ReadFile(journalPath(data))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading repair journal: %s", err)
+	}
+
+	journal := &repairJournal{}
+	err = json.Unmarshal(contents, journal)
+	if err != nil {
+		return rollbackJournal(data, journal)
+	}
+	for _, entry := range journal.Shards {
+		if entry.Index < 0 || entry.Index >= md.DataShards+md.ParityShards {
+			return fmt.Errorf("Repair journal references shard %d, have %d shards", entry.Index, md.DataShards+md.ParityShards)
+		}
+		// The data may have been re-encoded since the journal was written.
+		if entry.Hash == "" || entry.Hash != md.Hashes[entry.Index] {
+			return rollbackJournal(data, journal)
+		}
+		tempFile, err := os.Open(entry.TempFile)
+		if err != nil {
+			return rollbackJournal(data, journal)
+		}
+		tempHash, err := hashReader(tempFile)
+		tempFile.Close()
+		if err != nil || tempHash != entry.Hash {
+			return rollbackJournal(data, journal)
+		}
+	}
+	err = applyJournal(data, parityFiles, md, o, relocated, journal)
+	if err != nil && err != ErrShardRelocated {
+		// The repair was rolled back. Failing here would make the files
+		// impossible to open, so leave the shards to be repaired again,
+		// or rebuilt in memory, when they are next read.
+		return nil
+	}
+	return err
+}
+
+// applyJournal copies every reconstructed shard over its original, syncs the
+// originals and removes the journal. Shards that can't be written back go to
+// the FallbackDestination, if one is set, and ErrShardRelocated is returned
+// once everything else is committed. It is idempotent, so it is safe to run
+// again after a crash. If committing fails otherwise, e.g. because a shard is
+// read-only, the journal is rolled back rather than left for every later Open
+// to fail on: shards already copied match their hashes, and the rest are still
+// corrupt and are repaired again when they are next checked.
+func applyJournal(data *os.File, parityFiles []*os.File, md *Metadata, o options, relocated relocatedShards, journal *repairJournal) error {
+	var relocatedErr error
+	for _, entry := range journal.Shards {
+		tempFile, err := os.Open(entry.TempFile)
+		if err != nil {
+			rollbackJournal(data, journal)
+			return fmt.Errorf("Error opening repair file for shard %d: %s", entry.Index, err)
+		}
+		target := data
+		if entry.Index >= md.DataShards {
+			target = parityFiles[entry.Index-md.DataShards]
+		}
+		index := entry.Index
+		err = o.commitShard(index, tempFile, relocated, func(src io.Reader) error {
+			_, err := io.Copy(shardTarget(data, parityFiles, md, index), src)
+			if err != nil {
+				return err
+			}
+			return target.Sync()
+		})
+		tempFile.Close()
+		if err == ErrShardRelocated {
+			relocatedErr = err
+			continue
+		}
+		if err != nil {
+			rollbackJournal(data, journal)
+			return err
+		}
+	}
+	// Older versions wrote the padding of the last data shard past the end
+	// of the data, so drop anything beyond the recorded size.
+	err := truncateToSize(data, md.Size)
+	if err != nil {
+		rollbackJournal(data, journal)
+		return err
+	}
+
+	err = os.Remove(journalPath(data))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	removeJournalFiles(journal)
+	return relocatedErr
+}
+
+func rollbackJournal(data *os.File, journal *repairJournal) error {
+	err := os.Remove(journalPath(data))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	removeJournalFiles(journal)
+	return nil
+}
+
+func removeJournalFiles(journal *repairJournal) {
+	for _, entry := range journal.Shards {
+		os.Remove(entry.TempFile)
+	}
+}
+
+// writeJournal atomically replaces the journal at path: it is written to a
+// temporary file, synced and renamed into place.
+func writeJournal(path string, journal interface{}) error {
+	contents, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Error creating repair journal: %s", err)
+	}
+	_, err = tmpFile.Write(contents)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Error writing repair journal: %s", err)
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Error writing repair journal: %s", err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// updateJournal records the bytes of the data and parity files an Updater
+// write is about to overwrite. It is stored next to the data file until the
+// write and the new hashes are complete, so a write interrupted by a crash is
+// rolled back on the next Open or NewUpdater, leaving the files matching the
+// Metadata from before the write.
+type updateJournal struct {
+	Regions []journalRegion
+}
+
+type journalRegion struct {
+	// Index of the shard, data shards first, then parity shards.
+	Shard int
+	// Offset of the region within the shard.
+	Offset int64
+	// Contents of the region before the write.
+	Old []byte
+}
+
+func updateJournalPath(data *os.File) string {
+	return data.Name() + ".rsupdate"
+}
+
+// shardFile returns the file holding the shard with the given index and the
+// offset of the shard within it.
+func shardFile(data *os.File, parityFiles []*os.File, md *Metadata, index int) (*os.File, int64) {
+	if index < md.DataShards {
+		return data, int64(index) * paddedChunkSize(md.Size, md.DataShards)
+	}
+	return parityFiles[index-md.DataShards], 0
+}
+
+// rollbackUpdate writes the old contents recorded in journal back, syncs the
+// files and removes the update journal.
+func rollbackUpdate(data *os.File, parityFiles []*os.File, md *Metadata, journal *updateJournal) error {
+	for _, region := range journal.Regions {
+		f, shardOffset := shardFile(data, parityFiles, md, region.Shard)
+		_, err := f.WriteAt(region.Old, shardOffset+region.Offset)
+		if err != nil {
+			return fmt.Errorf("Error rolling back shard %d: %s", region.Shard, err)
+		}
+	}
+	err := syncFiles(data, parityFiles)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(updateJournalPath(data))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// recoverUpdateJournal looks for the journal of an interrupted Updater write
+// and rolls the write back.
+func recoverUpdateJournal(data *os.File, parityFiles []*os.File, md *Metadata) error {
+	contents, err := ioutil.ReadFile(updateJournalPath(data))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading update journal: %s", err)
+	}
+	journal := &updateJournal{}
+	err = json.Unmarshal(contents, journal)
+	if err != nil {
+		return fmt.Errorf("Error reading update journal: %s", err)
+	}
+	for _, region := range journal.Regions {
+		if region.Shard < 0 || region.Shard >= md.DataShards+md.ParityShards {
+			return fmt.Errorf("Update journal references shard %d, have %d shards", region.Shard, md.DataShards+md.ParityShards)
+		}
+	}
+	return rollbackUpdate(data, parityFiles, md, journal)
+}
+
+// syncFiles syncs the data and parity files.
+func syncFiles(data *os.File, parityFiles []*os.File) error {
+	err := data.Sync()
+	if err != nil {
+		return err
+	}
+	for _, parityFile := range parityFiles {
+		err := parityFile.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	// Some platforms can't sync directories; the rename is still durable on
+	// the ones that matter, so don't fail the repair over it.
+	dir.Sync()
+	return nil
+}
+
+// hashingWriter hashes everything written through it.
+type hashingWriter struct {
+	w      io.Writer
+	hasher hash.Hash
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, hasher: sha256.New()}
+}
+
+func (hw *hashingWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.hasher.Write(p[:n])
+	return n, err
+}
+
+// Hash returns the hex-encoded sha256 hash of everything written so far.
+func (hw *hashingWriter) Hash() string {
+	return fmt.Sprintf("%x", hw.hasher.Sum(nil))
+}
+
+// truncateToSize truncates f to size and syncs it if it is larger.
+func truncateToSize(f *os.File, size int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() <= size {
+		return nil
+	}
+	err = f.Truncate(size)
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,205 @@
+package rsutils
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestJournal leaves behind the journal of a repair that was interrupted
+// after reconstructing shardContents into a temp file.
+func writeTestJournal(t *testing.T, data *os.File, index int, shardContents []byte, hash string) string {
+	tempFile, err := ioutil.TempFile(filepath.Dir(data.Name()), "rsrepair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Remove(tempFile.Name())
+		os.Remove(journalPath(data))
+	})
+	_, err = tempFile.Write(shardContents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempFile.Close()
+
+	journal := &repairJournal{Shards: []journalEntry{{Index: index, TempFile: tempFile.Name(), Hash: hash}}}
+	err = writeJournal(journalPath(data), journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tempFile.Name()
+}
+
+func TestOpenResumesInterruptedRepair(t *testing.T) {
+	original := []byte("ABCDEFGH")
+	dataFile := CreateTMPFile(t, []byte("ABCDxxxx"))
+	parityFile := CreateTMPFile(t, []byte{})
+	md, err := Encode(CreateTMPFile(t, original), 2, []io.Writer{parityFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempFile := writeTestJournal(t, dataFile, 1, []byte("EFGH"), md.Hashes[1])
+
+	_, err = Open(dataFile, []*os.File{parityFile}, md)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	contents, _ := ioutil.ReadFile(dataFile.Name())
+	if !bytes.Equal(contents, original) {
+		t.Errorf("Got '%s', expected '%s'", contents, original)
+	}
+	if _, err := os.Stat(journalPath(dataFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected journal to be removed, got %v", err)
+	}
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		t.Errorf("Expected repair file to be removed, got %v", err)
+	}
+}
+
+func TestOpenRollsBackDamagedJournal(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDxxxx"))
+	parityFile := CreateTMPFile(t, []byte{})
+	md, err := Encode(CreateTMPFile(t, []byte("ABCDEFGH")), 2, []io.Writer{parityFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the repair file was only partially written
+	tempFile := writeTestJournal(t, dataFile, 1, []byte("EF"), md.Hashes[1])
+
+	_, err = Open(dataFile, []*os.File{parityFile}, md)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	contents, _ := ioutil.ReadFile(dataFile.Name())
+	if !bytes.Equal(contents, []byte("ABCDxxxx")) {
+		t.Errorf("Expected data to be left untouched, got '%s'", contents)
+	}
+	if _, err := os.Stat(journalPath(dataFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected journal to be removed, got %v", err)
+	}
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		t.Errorf("Expected repair file to be removed, got %v", err)
+	}
+}
+
+func TestRepairLeavesNoJournal(t *testing.T) {
+	dataInput := cloneFileTmp(t, getTestFile(t, "input4_corrupt")).(*os.File)
+	parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+
+	decoder, err := Open(dataInput, []*os.File{parityInput}, getMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = decoder.Read(make([]byte, 16))
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if _, err := os.Stat(journalPath(dataInput)); !os.IsNotExist(err) {
+		t.Errorf("Expected journal to be removed, got %v", err)
+	}
+	leftovers, err := filepath.Glob(dataInput.Name() + ".rsrepair*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) != 0 {
+		t.Errorf("Expected repair files to be removed, got %v", leftovers)
+	}
+}
+
+func TestOpenRollsBackJournalOfUnfinishedReconstruction(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDxxxx"))
+	parityFile := CreateTMPFile(t, []byte{})
+	md, err := Encode(CreateTMPFile(t, []byte("ABCDEFGH")), 2, []io.Writer{parityFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the journal is written without hashes before reconstruction starts
+	tempFile := writeTestJournal(t, dataFile, 1, []byte("EF"), "")
+
+	_, err = Open(dataFile, []*os.File{parityFile}, md)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		t.Errorf("Expected repair file to be removed, got %v", err)
+	}
+}
+
+func TestOpenRollsBackStaleJournal(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDxxxx"))
+	parityFile := CreateTMPFile(t, []byte{})
+	md, err := Encode(CreateTMPFile(t, []byte("ABCDEFGH")), 2, []io.Writer{parityFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the repair file is intact, but the data was re-encoded since
+	stale := []byte("WXYZ")
+	staleHash, err := hashReader(bytes.NewReader(stale))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestJournal(t, dataFile, 1, stale, staleHash)
+
+	_, err = Open(dataFile, []*os.File{parityFile}, md)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	contents, _ := ioutil.ReadFile(dataFile.Name())
+	if !bytes.Equal(contents, []byte("ABCDxxxx")) {
+		t.Errorf("Expected data to be left untouched, got '%s'", contents)
+	}
+	if _, err := os.Stat(journalPath(dataFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected journal to be removed, got %v", err)
+	}
+}
+
+func TestFailedRepairCommitLeavesFilesOpenable(t *testing.T) {
+	original := []byte("ABCDEFGHIJKL")
+	dataFile := CreateTMPFile(t, original)
+	md, parityFiles := encodeTmp(t, dataFile, 3, 2)
+	intactParity, err := ioutil.ReadFile(parityFiles[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, parityFiles[0], 1)
+	readOnlyParityFiles := reopenParityFile(t, parityFiles, os.O_RDONLY)
+
+	decoder, err := Open(dataFile, readOnlyParityFiles, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = decoder.Repair()
+	if err == nil {
+		t.Fatal("Expected repairing a read-only parity file to fail")
+	}
+	if journalExists(dataFile) {
+		t.Errorf("Expected the journal of the failed repair to be rolled back")
+	}
+
+	// a journal that can't be committed doesn't stop Open either
+	writeTestJournal(t, dataFile, 3, intactParity, md.Hashes[3])
+	for i := 0; i < 2; i++ {
+		decoder, err = Open(dataFile, readOnlyParityFiles, md)
+		if err != nil {
+			t.Fatalf("Expected nil error from Open, got %s", err)
+		}
+		buf := make([]byte, len(original))
+		_, err = decoder.ReadAt(buf, 0)
+		if err != nil {
+			t.Fatalf("Expected nil error, got %s", err)
+		}
+		if !bytes.Equal(buf, original) {
+			t.Errorf("Got '%s', expected '%s'", buf, original)
+		}
+	}
+	if journalExists(dataFile) {
+		t.Errorf("Expected the journal to be rolled back")
+	}
+}
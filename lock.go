@@ -0,0 +1,52 @@
+package rsutils
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLockTimeout is returned when the advisory lock on a shard can't be
+// acquired within the timeout set by WithLockTimeout.
+var ErrLockTimeout = errors.New("Timed out waiting for shard lock")
+
+// lockPollInterval is how often a lock held by somebody else is tried again
+// while waiting for it with a timeout.
+const lockPollInterval = 10 * time.Millisecond
+
+// fdFile is implemented by shards backed by a file descriptor, like *os.File.
+// Only those shards are locked.
+type fdFile interface {
+	Fd() uintptr
+}
+
+// shardLocks holds advisory locks on a set of shards.
+type shardLocks struct {
+	locked []fdFile
+}
+
+// lockShards takes a shared or an exclusive advisory lock on every shard that
+// is backed by a file descriptor. Shards are always locked in order, so
+// processes locking the same set of shards can't deadlock.
+func lockShards(shards []interface{}, exclusive bool, timeout time.Duration) (*shardLocks, error) {
+	locks := &shardLocks{locked: make([]fdFile, 0, len(shards))}
+	for _, shard := range shards {
+		f, ok := shard.(fdFile)
+		if !ok {
+			continue
+		}
+		err := lockFile(f.Fd(), exclusive, timeout)
+		if err != nil {
+			locks.unlock()
+			return nil, err
+		}
+		locks.locked = append(locks.locked, f)
+	}
+	return locks, nil
+}
+
+func (l *shardLocks) unlock() {
+	for i := len(l.locked) - 1; i >= 0; i-- {
+		unlockFile(l.locked[i].Fd())
+	}
+	l.locked = nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package rsutils
+
+import "time"
+
+// Advisory locks are not supported on this platform, locking always succeeds.
+func lockFile(fd uintptr, exclusive bool, timeout time.Duration) error {
+	return nil
+}
+
+func tryLockFile(fd uintptr, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(fd uintptr) error {
+	return nil
+}
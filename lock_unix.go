@@ -0,0 +1,66 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package rsutils
+
+import (
+	"syscall"
+	"time"
+)
+
+func lockFile(fd uintptr, exclusive bool, timeout time.Duration) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if timeout <= 0 {
+		for {
+			err := syscall.Flock(int(fd), how)
+			if err != syscall.EINTR {
+				return err
+			}
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(fd, exclusive)
+		if locked || err != nil {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ErrLockTimeout
+		}
+		if remaining > lockPollInterval {
+			remaining = lockPollInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// tryLockFile takes the lock if nobody else holds a conflicting one, without
+// waiting. It reports whether the lock was taken.
+func tryLockFile(fd uintptr, exclusive bool) (bool, error) {
+	how := syscall.LOCK_SH | syscall.LOCK_NB
+	if exclusive {
+		how = syscall.LOCK_EX | syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(fd), how)
+		switch err {
+		case nil:
+			return true, nil
+		case syscall.EWOULDBLOCK:
+			return false, nil
+		case syscall.EINTR:
+			continue
+		default:
+			return false, err
+		}
+	}
+}
+
+func unlockFile(fd uintptr) error {
+	return syscall.Flock(int(fd), syscall.LOCK_UN)
+}
@@ -0,0 +1,142 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package rsutils
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// holdLock opens a second handle on f and locks it, like another process would.
+func holdLock(t *testing.T, f *os.File, exclusive bool) *shardLocks {
+	other, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		other.Close()
+	})
+	locks, err := lockShards([]interface{}{other}, exclusive, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locks
+}
+
+func TestFileDecoderReadLockTimeout(t *testing.T) {
+	dataInput := cloneFileTmp(t, getTestFile(t, "input3")).(*os.File)
+	parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+
+	decoder, err := Open(dataInput, []*os.File{parityInput}, getMetadata(), WithLockTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locks := holdLock(t, parityInput, true)
+	_, err = decoder.Read(make([]byte, 16))
+	if err != ErrLockTimeout {
+		t.Errorf("Expected %s, got %v", ErrLockTimeout, err)
+	}
+
+	locks.unlock()
+	_, err = decoder.Read(make([]byte, 16))
+	if err != nil {
+		t.Errorf("Expected nil error, got %s", err)
+	}
+}
+
+func TestFileDecoderReadSharesLock(t *testing.T) {
+	dataInput := cloneFileTmp(t, getTestFile(t, "input3")).(*os.File)
+	parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+
+	decoder, err := Open(dataInput, []*os.File{parityInput}, getMetadata(), WithLockTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locks := holdLock(t, dataInput, false)
+	defer locks.unlock()
+	_, err = decoder.Read(make([]byte, 16))
+	if err != nil {
+		t.Errorf("Expected nil error, got %s", err)
+	}
+}
+
+func TestShardManagerRepairLockTimeout(t *testing.T) {
+	shards := getShards(t)
+	md := getMetadata()
+	err := corruptShard(shards[0], int(md.Size)/md.DataShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locks := holdLock(t, shards[1].(*os.File), false)
+	manager := NewShardManager(shards, md, WithLockTimeout(20*time.Millisecond))
+
+	err = manager.CheckHealth()
+	if err == nil || err == ErrLockTimeout {
+		t.Errorf("Expected corruption to be reported, got %v", err)
+	}
+	err = manager.Repair()
+	if err != ErrLockTimeout {
+		t.Errorf("Expected %s, got %v", ErrLockTimeout, err)
+	}
+
+	locks.unlock()
+	for _, shard := range shards {
+		shard.Seek(0, io.SeekStart)
+	}
+	err = manager.Repair()
+	if err != nil {
+		t.Errorf("Expected nil error, got %s", err)
+	}
+}
+
+func TestOpenDoesNotWaitForSharedLock(t *testing.T) {
+	dataInput := cloneFileTmp(t, getTestFile(t, "input3")).(*os.File)
+	parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+
+	holdLock(t, dataInput, false)
+	_, err := Open(dataInput, []*os.File{parityInput}, getMetadata(), WithLockTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Errorf("Expected nil error, got %s", err)
+	}
+
+	writeTestJournal(t, dataInput, 0, []byte{}, "")
+	_, err = Open(dataInput, []*os.File{parityInput}, getMetadata(), WithLockTimeout(20*time.Millisecond))
+	if err != ErrLockTimeout {
+		t.Errorf("Expected %s with a journal to recover, got %v", ErrLockTimeout, err)
+	}
+}
+
+func TestUpdaterWriteAtWaitsForLocks(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDEFGHIJKL"))
+	md, parityFiles := encodeTmp(t, dataFile, 3, 1)
+	updater, err := NewUpdater(dataFile, parityFiles, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a FileDecoder in another process is verifying the shards
+	locks := holdLock(t, parityFiles[0], false)
+	done := make(chan error, 1)
+	go func() {
+		_, err := updater.WriteAt([]byte("xy"), 5)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected WriteAt to wait for the lock, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	locks.unlock()
+	err = <-done
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	assertMetadataMatchesEncode(t, md, []byte("ABCDExyHIJKL"))
+}
@@ -0,0 +1,291 @@
+package rsutils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// defaultBlockSize is the size of the blocks shards are processed in when no
+// size is set with WithStreamBlockSize, the same as klauspost/reedsolomon's.
+const defaultBlockSize = 4 << 20
+
+func (o options) blockSize() int {
+	if o.streamBlockSize > 0 {
+		return o.streamBlockSize
+	}
+	return defaultBlockSize
+}
+
+// splitLocalGroups splits dataShards data shards into consecutive groups of
+// groupSize shards; the last group may be smaller.
+func splitLocalGroups(dataShards, groupSize int) [][]int {
+	groups := make([][]int, 0)
+	for start := 0; start < dataShards; start += groupSize {
+		group := make([]int, 0, groupSize)
+		for i := start; i < start+groupSize && i < dataShards; i++ {
+			group = append(group, i)
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// localParityIndex returns the index of the local parity shard of group g,
+// which follow the data and global parity shards.
+func (md *Metadata) localParityIndex(g int) int {
+	return md.DataShards + md.ParityShards + g
+}
+
+// xorBlock XORs src into dst.
+func xorBlock(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
+
+// xorShards writes the XOR of srcs to dst, reading them blockSize bytes at a
+// time. Shorter sources are treated as if padded with zeros.
+func xorShards(srcs []io.Reader, dst io.Writer, blockSize int) error {
+	sum := make([]byte, blockSize)
+	block := make([]byte, blockSize)
+	for {
+		for i := range sum {
+			sum[i] = 0
+		}
+		longest := 0
+		for _, src := range srcs {
+			n, err := io.ReadFull(src, block)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			xorBlock(sum, block[:n])
+			if n > longest {
+				longest = n
+			}
+		}
+		if longest == 0 {
+			return nil
+		}
+		_, err := dst.Write(sum[:longest])
+		if err != nil {
+			return err
+		}
+		if longest < blockSize {
+			return nil
+		}
+	}
+}
+
+// encodeWithLocalGroups encodes the data shards block by block into global
+// parity shards, computed with Reed-Solomon, and one local parity shard per
+// group of data shards, the XOR of the shards in the group. parityDst holds
+// the global parity writers followed by the local ones.
+func (p *ShardCreator) encodeWithLocalGroups(parityDst []io.Writer) (*Metadata, error) {
+	md := &Metadata{
+		Size:         p.size,
+		DataShards:   p.dataShards,
+		ParityShards: p.parityShards,
+		Matrix:       p.opts.matrix,
+		LocalGroups:  splitLocalGroups(p.dataShards, p.opts.localGroupSize),
+	}
+	if len(parityDst) != p.parityShards+len(md.LocalGroups) {
+		return nil, fmt.Errorf("Error encoding: need %d global and %d local parity writers, got %d", p.parityShards, len(md.LocalGroups), len(parityDst))
+	}
+	encoder, err := newBlockEncoder(md)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating reedsolomon encoder: %s", err)
+	}
+
+	shardCount := p.dataShards + len(parityDst)
+	blockSize := p.opts.blockSize()
+	blocks := make([][]byte, shardCount)
+	hashers := make([]hash.Hash, shardCount)
+	for i := range blocks {
+		blocks[i] = make([]byte, blockSize)
+		hashers[i] = sha256.New()
+	}
+	var bytesHashed int64
+	for {
+		n := -1
+		for i, dataSource := range p.dataSources {
+			read, err := io.ReadFull(dataSource, blocks[i])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return nil, fmt.Errorf("Error encoding: %s", err)
+			}
+			if n == -1 {
+				n = read
+			} else if read != n {
+				return nil, fmt.Errorf("Error encoding: data shard %d differs in size", i)
+			}
+		}
+		if n == 0 {
+			break
+		}
+
+		shards := make([][]byte, shardCount)
+		for i := range shards {
+			shards[i] = blocks[i][:n]
+		}
+		err = encoder.Encode(shards[:p.dataShards+p.parityShards])
+		if err != nil {
+			return nil, fmt.Errorf("Error encoding: %s", err)
+		}
+		for g, group := range md.LocalGroups {
+			localParity := shards[md.localParityIndex(g)]
+			for i := range localParity {
+				localParity[i] = 0
+			}
+			for _, i := range group {
+				xorBlock(localParity, shards[i])
+			}
+		}
+
+		for i, shard := range shards {
+			hashers[i].Write(shard)
+			bytesHashed += int64(n)
+			if i >= p.dataShards {
+				_, err = parityDst[i-p.dataShards].Write(shard)
+				if err != nil {
+					return nil, fmt.Errorf("Error encoding: %s", err)
+				}
+			}
+		}
+		if n < blockSize {
+			break
+		}
+	}
+	p.opts.metrics.BytesHashed(OpShardCreatorEncode, bytesHashed)
+
+	md.Hashes = make([]string, shardCount)
+	for i := range hashers {
+		md.Hashes[i] = fmt.Sprintf("%x", hashers[i].Sum(nil))
+	}
+	return md, nil
+}
+
+// repairWithLocalGroups repairs the broken shards of a stripe with local
+// groups. A group with a single broken shard is repaired from the rest of the
+// group alone. Broken data and global parity shards left after that are
+// reconstructed with Reed-Solomon, and broken local parity shards recomputed
+// from their repaired groups. ErrShardRelocated is returned at the end if any
+// shard could only be written to the FallbackDestination.
+func (p *ShardManager) repairWithLocalGroups(brokenShardIndexes []int) error {
+	md := p.Metadata
+	var relocatedErr error
+	broken := make(map[int]bool)
+	for _, i := range brokenShardIndexes {
+		broken[i] = true
+	}
+
+	for g, group := range md.LocalGroups {
+		members := append(append([]int{}, group...), md.localParityIndex(g))
+		brokenMembers := make([]int, 0)
+		intactMembers := make([]int, 0)
+		for _, i := range members {
+			if broken[i] {
+				brokenMembers = append(brokenMembers, i)
+			} else {
+				intactMembers = append(intactMembers, i)
+			}
+		}
+		if len(brokenMembers) != 1 {
+			continue
+		}
+		err := p.xorInto(brokenMembers[0], intactMembers)
+		if err == ErrShardRelocated {
+			relocatedErr = err
+			err = nil
+		}
+		if err == errReconstructionMismatch {
+			// Leave it to Reed-Solomon, which can do without the bad
+			// member of the group.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Error repairing shard %d from its local group: %s", brokenMembers[0], err)
+		}
+		delete(broken, brokenMembers[0])
+	}
+
+	globalShards := make([]int, 0)
+	for _, i := range brokenShardIndexes {
+		if broken[i] && i < md.DataShards+md.ParityShards {
+			globalShards = append(globalShards, i)
+		}
+	}
+	if len(globalShards) > 0 {
+		err := p.rewind()
+		if err != nil {
+			return err
+		}
+		err = p.repairShards(globalShards)
+		if err == ErrShardRelocated {
+			relocatedErr = err
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for g, group := range md.LocalGroups {
+		if !broken[md.localParityIndex(g)] {
+			continue
+		}
+		err := p.xorInto(md.localParityIndex(g), group)
+		if err == ErrShardRelocated {
+			relocatedErr = err
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error recomputing local parity shard %d: %s", md.localParityIndex(g), err)
+		}
+	}
+	return relocatedErr
+}
+
+// xorInto overwrites shard dst with the XOR of the shards srcs, unless that
+// doesn't match the hash of dst, in which case it returns
+// errReconstructionMismatch and leaves dst as it is.
+func (p *ShardManager) xorInto(dst int, srcs []int) error {
+	err := p.rewind()
+	if err != nil {
+		return err
+	}
+	readers := make([]io.Reader, len(srcs))
+	for i, src := range srcs {
+		readers[i] = p.source(src)
+	}
+	repaired, err := newRepairFiles([]int{dst})
+	if err != nil {
+		return err
+	}
+	defer repaired.remove()
+	hashWriter := newHashingWriter(repaired[dst])
+	err = xorShards(readers, hashWriter, p.opts.blockSize())
+	if err != nil {
+		return err
+	}
+	if hashWriter.Hash() != p.Metadata.Hashes[dst] {
+		return errReconstructionMismatch
+	}
+	return p.commitShard(dst, repaired[dst])
+}
+
+// rewind seeks every shard that hasn't timed out back to its start, starting
+// their deadlines for the pass over them that follows.
+func (p *ShardManager) rewind() error {
+	for i := range p.DataSources {
+		if !p.available(i) {
+			continue
+		}
+		p.startDeadline(i)
+		_, err := p.source(i).Seek(0, io.SeekStart)
+		if err != nil {
+			return fmt.Errorf("Error rewinding shard %d: %s", i, err)
+		}
+	}
+	return nil
+}
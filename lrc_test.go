@@ -0,0 +1,214 @@
+package rsutils
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// readCountingShard counts the bytes read from a shard.
+type readCountingShard struct {
+	io.ReadWriteSeeker
+	read int64
+}
+
+func (s *readCountingShard) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteSeeker.Read(p)
+	s.read += int64(n)
+	return n, err
+}
+
+// flipByte inverts the byte at off in f, which unlike corruptShard is
+// guaranteed to change random data.
+func flipByte(t *testing.T, f *os.File, off int64) {
+	b := make([]byte, 1)
+	_, err := f.ReadAt(b, off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, off)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// encodeLocalGroupsTmp encodes 4 random data shards of shardSize bytes into 2
+// global parity shards and local groups of 2 shards, returning the shards
+// stored in temporary files.
+func encodeLocalGroupsTmp(t *testing.T, shardSize int) (*Metadata, [][]byte, []*os.File) {
+	rng := rand.New(rand.NewSource(40))
+	contents := make([][]byte, 4)
+	dataSources := make([]io.Reader, len(contents))
+	for i := range contents {
+		contents[i] = make([]byte, shardSize)
+		rng.Read(contents[i])
+		dataSources[i] = bytes.NewReader(contents[i])
+	}
+	parityFiles := make([]*os.File, 4)
+	parityWriters := make([]io.Writer, len(parityFiles))
+	for i := range parityFiles {
+		parityFiles[i] = CreateTMPFile(t, []byte{})
+		parityWriters[i] = parityFiles[i]
+	}
+
+	creator := NewShardCreator(dataSources, int64(shardSize*len(contents)), 4, 2, WithLocalGroups(2), WithStreamBlockSize(64))
+	md, err := creator.Encode(parityWriters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shards := make([]*os.File, 0, 8)
+	for i := range contents {
+		shards = append(shards, CreateTMPFile(t, contents[i]))
+	}
+	shards = append(shards, parityFiles...)
+	for _, shard := range shards {
+		_, err = shard.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return md, contents, shards
+}
+
+func TestShardCreatorEncodeWithLocalGroups(t *testing.T) {
+	md, contents, shards := encodeLocalGroupsTmp(t, 1000)
+
+	expectedGroups := [][]int{{0, 1}, {2, 3}}
+	if !reflect.DeepEqual(md.LocalGroups, expectedGroups) {
+		t.Errorf("Got local groups %v, expected %v", md.LocalGroups, expectedGroups)
+	}
+	if len(md.Hashes) != 8 {
+		t.Fatalf("Got %d hashes, expected 8", len(md.Hashes))
+	}
+	localParity, err := ioutil.ReadFile(shards[7].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, len(contents[2]))
+	xorBlock(expected, contents[2])
+	xorBlock(expected, contents[3])
+	if !bytes.Equal(localParity, expected) {
+		t.Errorf("Expected the local parity of group 1 to be the XOR of shards 2 and 3")
+	}
+}
+
+func TestShardManagerRepairWithLocalGroups(t *testing.T) {
+	tests := []struct {
+		name         string
+		brokenShards []int
+		expectedErr  bool
+	}{
+		{"one data shard", []int{1}, false},
+		{"one local parity shard", []int{6}, false},
+		{"two data shards in a group", []int{0, 1}, false},
+		{"one shard per group and a global parity shard", []int{0, 3, 4}, false},
+		{"two data shards and their local parity", []int{2, 3, 7}, false},
+		{"too many in one group", []int{0, 1, 4, 5}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, _, shards := encodeLocalGroupsTmp(t, 1000)
+			sources := make([]io.ReadWriteSeeker, len(shards))
+			for i := range shards {
+				sources[i] = shards[i]
+			}
+			for _, i := range tt.brokenShards {
+				flipByte(t, shards[i], 500)
+			}
+
+			err := NewShardManager(sources, md).Repair()
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("Expected an error repairing shards %v", tt.brokenShards)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			for _, source := range sources {
+				_, err = source.Seek(0, io.SeekStart)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = NewShardManager(sources, md).CheckHealth()
+			if err != nil {
+				t.Errorf("Expected nil error after repair, got %s", err)
+			}
+		})
+	}
+}
+
+func TestShardManagerLocalRepairReadsOnlyGroup(t *testing.T) {
+	md, _, shards := encodeLocalGroupsTmp(t, 1000)
+	flipByte(t, shards[0], 500)
+	counters := make([]*readCountingShard, len(shards))
+	sources := make([]io.ReadWriteSeeker, len(shards))
+	for i := range shards {
+		counters[i] = &readCountingShard{ReadWriteSeeker: shards[i]}
+		sources[i] = counters[i]
+	}
+
+	err := NewShardManager(sources, md).Repair()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	// Every shard is read once to verify it; only the rest of the group of
+	// shard 0, shard 1 and its local parity shard 6, are read again.
+	for i, counter := range counters {
+		expected := int64(1000)
+		if i == 1 || i == 6 {
+			expected = 2000
+		}
+		if counter.read != expected {
+			t.Errorf("Read %d bytes of shard %d, expected %d", counter.read, i, expected)
+		}
+	}
+}
+
+func TestEncodeRefusesLocalGroups(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCD"))
+	var parityBuffer bytes.Buffer
+
+	expectedErrMsg := "Cannot encode: local groups are only supported by ShardCreator"
+	_, err := Encode(dataFile, 2, []io.Writer{&parityBuffer}, WithLocalGroups(2))
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
+
+func TestOpenRefusesLocalGroups(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCD"))
+	md, parityFiles := encodeTmp(t, dataFile, 2, 1)
+	md.LocalGroups = [][]int{{0, 1}}
+
+	expectedErrMsg := "Cannot open encoded files: local groups are only supported by ShardManager"
+	_, err := Open(dataFile, parityFiles, md)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
+
+func TestUpdaterRefusesLocalGroups(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCD"))
+	md, parityFiles := encodeTmp(t, dataFile, 2, 1)
+	md.LocalGroups = [][]int{{0, 1}}
+
+	expectedErrMsg := "Cannot open encoded files: local groups are only supported by ShardManager"
+	_, err := NewUpdater(dataFile, parityFiles, md)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s' from NewUpdater, got '%v'", expectedErrMsg, err)
+	}
+	_, err = NewProtectedFile(dataFile, parityFiles, md)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s' from NewProtectedFile, got '%v'", expectedErrMsg, err)
+	}
+}
@@ -0,0 +1,90 @@
+package rsutils
+
+import (
+	"fmt"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Matrix is the type of encoding matrix used to compute parity shards. Shards
+// can only be reconstructed with the matrix they were encoded with, so it is
+// recorded in Metadata.
+type Matrix string
+
+const (
+	// VandermondeMatrix is the default matrix of klauspost/reedsolomon. It is
+	// recorded as an empty string.
+	VandermondeMatrix Matrix = ""
+	// CauchyMatrix is a Cauchy matrix, which is faster to build for large
+	// numbers of shards.
+	CauchyMatrix Matrix = "cauchy"
+	// PAR1Matrix is the matrix used by PAR1. It is only meant for
+	// compatibility with PAR1 files: some combinations of missing shards
+	// can't be reconstructed with it.
+	PAR1Matrix Matrix = "par1"
+)
+
+func (m Matrix) String() string {
+	if m == VandermondeMatrix {
+		return "vandermonde"
+	}
+	return string(m)
+}
+
+// encoderOptions returns the klauspost/reedsolomon options selecting the
+// matrix.
+func (m Matrix) encoderOptions() ([]reedsolomon.Option, error) {
+	switch m {
+	case VandermondeMatrix:
+		return nil, nil
+	case CauchyMatrix:
+		return []reedsolomon.Option{reedsolomon.WithCauchyMatrix()}, nil
+	case PAR1Matrix:
+		return []reedsolomon.Option{reedsolomon.WithPAR1Matrix()}, nil
+	default:
+		return nil, fmt.Errorf("Unknown encoding matrix '%s'", string(m))
+	}
+}
+
+// checkMatrix refuses to decode shards encoded with a different matrix than
+// the one explicitly requested with WithMatrix.
+func (o options) checkMatrix(md *Metadata) error {
+	if o.matrixSet && o.matrix != md.Matrix {
+		return fmt.Errorf("Shards were encoded with the %s matrix, not %s", md.Matrix, o.matrix)
+	}
+	return nil
+}
+
+// newStreamEncoder returns a stream encoder using the given matrix and the
+// stream options set with WithStreamBlockSize and WithConcurrentStreams.
+func (o options) newStreamEncoder(dataShards, parityShards int, matrix Matrix) (reedsolomon.StreamEncoder, error) {
+	rsOpts, err := matrix.encoderOptions()
+	if err != nil {
+		return nil, err
+	}
+	if o.streamBlockSize > 0 {
+		rsOpts = append(rsOpts, reedsolomon.WithStreamBlockSize(o.streamBlockSize))
+	}
+	if o.concurrentStreams {
+		rsOpts = append(rsOpts, reedsolomon.WithConcurrentStreams(true))
+	}
+	return reedsolomon.NewStream(dataShards, parityShards, rsOpts...)
+}
+
+// newDecoder returns a stream encoder matching md, for reconstructing shards.
+func (o options) newDecoder(md *Metadata) (reedsolomon.StreamEncoder, error) {
+	err := o.checkMatrix(md)
+	if err != nil {
+		return nil, err
+	}
+	return o.newStreamEncoder(md.DataShards, md.ParityShards, md.Matrix)
+}
+
+// newBlockEncoder returns an in-memory encoder matching md.
+func newBlockEncoder(md *Metadata) (reedsolomon.Encoder, error) {
+	rsOpts, err := md.Matrix.encoderOptions()
+	if err != nil {
+		return nil, err
+	}
+	return reedsolomon.New(md.DataShards, md.ParityShards, rsOpts...)
+}
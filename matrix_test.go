@@ -0,0 +1,141 @@
+package rsutils
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEncodeRecordsMatrix(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vandermondeMd, _ := encodeTmp(t, CreateTMPFile(t, original), 3, 2)
+	if vandermondeMd.Matrix != VandermondeMatrix {
+		t.Errorf("Got matrix %s, expected %s", vandermondeMd.Matrix, VandermondeMatrix)
+	}
+
+	for _, matrix := range []Matrix{CauchyMatrix, PAR1Matrix} {
+		t.Run(matrix.String(), func(t *testing.T) {
+			md, _ := encodeTmp(t, CreateTMPFile(t, original), 3, 2, WithMatrix(matrix))
+			if md.Matrix != matrix {
+				t.Errorf("Got matrix %s, expected %s", md.Matrix, matrix)
+			}
+			if md.Hashes[4] == vandermondeMd.Hashes[4] {
+				t.Errorf("Expected parity shards to differ from the %s matrix", VandermondeMatrix)
+			}
+		})
+	}
+}
+
+func TestFileDecoderRepairsWithRecordedMatrix(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataFile := CreateTMPFile(t, original)
+	md, parityFiles := encodeTmp(t, dataFile, 3, 2, WithMatrix(CauchyMatrix))
+	_, err = dataFile.WriteAt([]byte{0xff, 0xfe}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parityFiles[0].WriteAt([]byte{0xff, 0xfe}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := Open(dataFile, parityFiles, md, WithStreamBlockSize(16), WithConcurrentStreams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = decoder.Repair()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	buf := make([]byte, md.Size)
+	_, err = decoder.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(buf, original) {
+		t.Errorf("Got contents:\n%s\nexpected:\n%s", buf, original)
+	}
+}
+
+func TestOpenRefusesMismatchedMatrix(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDEFGH"))
+	md, parityFiles := encodeTmp(t, dataFile, 2, 2, WithMatrix(CauchyMatrix))
+
+	expectedErrMsg := "Cannot open encoded files: Shards were encoded with the cauchy matrix, not vandermonde"
+	_, err := Open(dataFile, parityFiles, md, WithMatrix(VandermondeMatrix))
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
+
+func TestShardManagerRepairWithMatrix(t *testing.T) {
+	input1 := getTestFile(t, "input1")
+	input2 := getTestFile(t, "input2")
+	inputStat, err := input1.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := inputStat.Size() * 2
+	creator := NewShardCreator([]io.Reader{input1, input2}, size, 2, 2, WithMatrix(CauchyMatrix))
+	parityFiles := []*os.File{CreateTMPFile(t, []byte{}), CreateTMPFile(t, []byte{})}
+	md, err := creator.Encode([]io.Writer{parityFiles[0], parityFiles[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Matrix != CauchyMatrix {
+		t.Fatalf("Got matrix %s, expected %s", md.Matrix, CauchyMatrix)
+	}
+
+	shards := getShards(t)[:2]
+	for _, parityFile := range parityFiles {
+		_, err = parityFile.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, parityFile)
+	}
+	err = corruptShard(shards[0], int(md.Size)/md.DataShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedErrMsg := "Error creating reedsolomon encoder: Shards were encoded with the cauchy matrix, not par1"
+	err = NewShardManager(shards, md, WithMatrix(PAR1Matrix)).Repair()
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+
+	err = NewShardManager(shards, md).Repair()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	for _, shard := range shards {
+		_, err = shard.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = NewShardManager(shards, md).CheckHealth()
+	if err != nil {
+		t.Errorf("Expected nil error after repair, got %s", err)
+	}
+}
+
+func TestUnknownMatrix(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCD"))
+	var parityBuffer bytes.Buffer
+
+	expectedErrMsg := "Unknown encoding matrix 'hilbert'"
+	_, err := Encode(dataFile, 2, []io.Writer{&parityBuffer}, WithMatrix("hilbert"))
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
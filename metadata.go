@@ -5,4 +5,12 @@ type Metadata struct {
 	Hashes       []string
 	DataShards   int
 	ParityShards int
+	// Matrix is the encoding matrix the parity shards were computed with.
+	// Metadata written before it was recorded decodes to VandermondeMatrix.
+	Matrix Matrix
+	// LocalGroups lists the data shards in each local group, if the shards
+	// were encoded with WithLocalGroups. Every group has a local parity
+	// shard, the XOR of its data shards, stored after the global parity
+	// shards in the order of the groups.
+	LocalGroups [][]int
 }
@@ -0,0 +1,179 @@
+package rsutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const metadataXattr = "user.rsutils.metadata"
+
+// storedMetadata is the form in which Metadata is kept next to the data file it
+// describes, together with the locations of the parity files. Parity file
+// paths are relative to the directory of the data file unless absolute.
+type storedMetadata struct {
+	Metadata
+	ParityFiles []string
+}
+
+func sidecarPath(path string) string {
+	return path + ".rsmeta"
+}
+
+// newStoredMetadata pairs md with the paths of the parity files, relative to
+// the directory of the data file where possible.
+func newStoredMetadata(data *os.File, md *Metadata, parityWriters []io.Writer) (*storedMetadata, error) {
+	dataDir, err := filepath.Abs(filepath.Dir(data.Name()))
+	if err != nil {
+		return nil, err
+	}
+	stored := &storedMetadata{Metadata: *md, ParityFiles: make([]string, len(parityWriters))}
+	for i, parityWriter := range parityWriters {
+		parityFile, ok := parityWriter.(*os.File)
+		if !ok {
+			return nil, fmt.Errorf("Cannot store metadata: parity writer %d is not a file", i)
+		}
+		parityPath, err := filepath.Abs(parityFile.Name())
+		if err != nil {
+			return nil, err
+		}
+		if relPath, err := filepath.Rel(dataDir, parityPath); err == nil {
+			parityPath = relPath
+		}
+		stored.ParityFiles[i] = parityPath
+	}
+	return stored, nil
+}
+
+// storeMetadata serializes md and the paths of the parity files into the
+// metadata extended attribute of data, falling back to a sidecar file when the
+// attribute can't be set.
+func storeMetadata(data *os.File, md *Metadata, parityWriters []io.Writer) error {
+	stored, err := newStoredMetadata(data, md, parityWriters)
+	if err != nil {
+		return err
+	}
+	return saveStoredMetadata(data.Name(), stored)
+}
+
+// saveStoredMetadata writes stored to the metadata extended attribute of the
+// data file at path, or to its sidecar file if the attribute can't be set.
+func saveStoredMetadata(path string, stored *storedMetadata) error {
+	contents, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if setXattr(path, metadataXattr, contents) == nil {
+		return nil
+	}
+	// Not every filesystem supports extended attributes, and those that do
+	// limit their size, so keep the metadata in a sidecar file instead.
+	return writeSidecar(path, stored)
+}
+
+func writeSidecar(path string, stored *storedMetadata) error {
+	contents, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(sidecarPath(path), contents, 0644)
+	if err != nil {
+		return fmt.Errorf("Cannot store metadata: %s", err)
+	}
+	return nil
+}
+
+// readSidecar reads the Metadata stored in the sidecar file of the data file
+// at path.
+func readSidecar(path string) (*storedMetadata, error) {
+	contents, err := ioutil.ReadFile(sidecarPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot find metadata for %s: %s", path, err)
+	}
+	return parseStoredMetadata(path, contents)
+}
+
+// loadMetadata reads the Metadata stored for the data file at path by
+// storeMetadata, from its extended attribute or from its sidecar file.
+func loadMetadata(path string) (*storedMetadata, error) {
+	contents, err := getXattr(path, metadataXattr)
+	if err != nil {
+		return readSidecar(path)
+	}
+	return parseStoredMetadata(path, contents)
+}
+
+func parseStoredMetadata(path string, contents []byte) (*storedMetadata, error) {
+	stored := &storedMetadata{}
+	err := json.Unmarshal(contents, stored)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read metadata for %s: %s", path, err)
+	}
+	if len(stored.ParityFiles) != stored.ParityShards {
+		return nil, fmt.Errorf("Cannot read metadata for %s: need %d parity files, got %d", path, stored.ParityShards, len(stored.ParityFiles))
+	}
+	return stored, nil
+}
+
+// openReadWrite opens path for reading and writing so shards can be repaired
+// in place, or read-only if that is all the filesystem allows.
+func openReadWrite(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil && !os.IsNotExist(err) {
+		return os.Open(path)
+	}
+	return f, err
+}
+
+// OpenPath opens the data file at path together with the parity files and
+// Metadata that Encode stored for it with WithMetadataXattr.
+// The returned FileDecoder owns the files; call Close when done with it.
+func OpenPath(path string, opts ...Option) (*FileDecoder, error) {
+	stored, err := loadMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	return openStored(path, stored, saveStoredMetadata, opts)
+}
+
+// openStored opens the data file at path and the parity files listed in stored.
+// save is used to store the Metadata again when the FileDecoder changes it,
+// e.g. when re-encoding modified data.
+func openStored(path string, stored *storedMetadata, save func(path string, stored *storedMetadata) error, opts []Option) (*FileDecoder, error) {
+	parityFiles := make([]*os.File, 0, len(stored.ParityFiles))
+	closeAll := func() {
+		for _, parityFile := range parityFiles {
+			parityFile.Close()
+		}
+	}
+	for _, parityPath := range stored.ParityFiles {
+		if !filepath.IsAbs(parityPath) {
+			parityPath = filepath.Join(filepath.Dir(path), parityPath)
+		}
+		parityFile, err := openReadWrite(parityPath)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		parityFiles = append(parityFiles, parityFile)
+	}
+	data, err := openReadWrite(path)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	decoder, err := Open(data, parityFiles, &stored.Metadata, opts...)
+	if err != nil {
+		data.Close()
+		closeAll()
+		return nil, err
+	}
+	decoder.saveMetadata = func() error {
+		return save(path, stored)
+	}
+	return decoder, nil
+}
@@ -0,0 +1,125 @@
+package rsutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createFileIn(t *testing.T, dir, name string, contents []byte) *os.File {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+	})
+	_, err = f.Write(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestEncodeWithMetadataXattr(t *testing.T) {
+	dir := t.TempDir()
+	original, err := ioutil.ReadFile("testdata/input3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataFile := createFileIn(t, dir, "data", original)
+	parityFile := createFileIn(t, dir, "parity", []byte{})
+
+	md, err := Encode(dataFile, 2, []io.Writer{parityFile}, WithMetadataXattr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := loadMetadata(dataFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Size != md.Size || stored.ParityFiles[0] != "parity" {
+		t.Errorf("Got stored metadata %+v, expected %+v with parity file 'parity'", stored, md)
+	}
+
+	err = corruptShard(dataFile, int(md.Size)/md.DataShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := OpenPath(dataFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	contents := make([]byte, md.Size)
+	_, err = decoder.ReadAt(contents, 0)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(contents, original) {
+		t.Errorf("Got contents:\n%s\nexpected:\n%s", contents, original)
+	}
+}
+
+func TestOpenPathSidecarFallback(t *testing.T) {
+	dir := t.TempDir()
+	original, err := ioutil.ReadFile("testdata/input3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataFile := createFileIn(t, dir, "data", original)
+	parityFile := createFileIn(t, dir, "parity", []byte{})
+	md, err := Encode(dataFile, 2, []io.Writer{parityFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := json.Marshal(&storedMetadata{Metadata: *md, ParityFiles: []string{"parity"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(sidecarPath(dataFile.Name()), contents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := OpenPath(dataFile.Name())
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	defer decoder.Close()
+	buf := make([]byte, 16)
+	_, err = decoder.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(buf, original[:16]) {
+		t.Errorf("Got '%s', expected '%s'", buf, original[:16])
+	}
+}
+
+func TestOpenPathWithoutMetadata(t *testing.T) {
+	dir := t.TempDir()
+	dataFile := createFileIn(t, dir, "data", []byte("ABCD"))
+
+	_, err := OpenPath(dataFile.Name())
+	if err == nil {
+		t.Errorf("Expected an error when no metadata was stored")
+	}
+}
+
+func TestEncodeWithMetadataXattrNeedsFiles(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCD"))
+	var parityBuffer bytes.Buffer
+
+	expectedErrMsg := "Cannot store metadata: parity writer 0 is not a file"
+	_, err := Encode(dataFile, 2, []io.Writer{&parityBuffer}, WithMetadataXattr())
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
@@ -0,0 +1,84 @@
+package rsutils
+
+import (
+	"io"
+	"time"
+)
+
+// Operations reported to Metrics.
+const (
+	// OpEncode is Encode.
+	OpEncode = "encode"
+	// OpShardCreatorEncode is ShardCreator.Encode.
+	OpShardCreatorEncode = "shard_creator_encode"
+	// OpCheckHealth is ShardManager.CheckHealth and FileDecoder.CheckHealth.
+	OpCheckHealth = "check_health"
+	// OpRepair is ShardManager.Repair and FileDecoder.Repair.
+	OpRepair = "repair"
+	// OpRead is FileDecoder.Read and FileDecoder.ReadAt.
+	OpRead = "read"
+	// OpSplit is Split.
+	OpSplit = "split"
+	// OpJoin is Join.
+	OpJoin = "join"
+	// OpDecode is Decode.
+	OpDecode = "decode"
+)
+
+// Metrics receives measurements from encoding, verification and repair, set
+// with WithMetrics. Every measurement is labelled with the operation it was
+// taken in, one of the Op constants. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	// BytesHashed is called with the number of shard bytes hashed.
+	BytesHashed(op string, n int64)
+	// CorruptShardsFound is called with the number of corrupt shards found
+	// whenever an operation finds any.
+	CorruptShardsFound(op string, n int)
+	// RepairSucceeded is called after corrupt shards were repaired.
+	RepairSucceeded(op string)
+	// RepairFailed is called after an attempt to repair corrupt shards failed.
+	RepairFailed(op string)
+	// ObserveLatency is called with the duration of every operation.
+	ObserveLatency(op string, d time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) BytesHashed(op string, n int64)            {}
+func (nopMetrics) CorruptShardsFound(op string, n int)       {}
+func (nopMetrics) RepairSucceeded(op string)                 {}
+func (nopMetrics) RepairFailed(op string)                    {}
+func (nopMetrics) ObserveLatency(op string, d time.Duration) {}
+
+// observeLatency reports the time elapsed since start. It is meant to be
+// deferred at the top of an operation.
+func observeLatency(m Metrics, op string, start time.Time) {
+	m.ObserveLatency(op, time.Since(start))
+}
+
+// reportRepair reports the outcome of a repair attempt.
+func reportRepair(m Metrics, op string, err error) {
+	if err != nil {
+		m.RepairFailed(op)
+	} else {
+		m.RepairSucceeded(op)
+	}
+}
+
+// countingWriter counts the bytes written through it and remembers the first
+// error returned by w.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	if cw.err == nil {
+		cw.err = err
+	}
+	return n, err
+}
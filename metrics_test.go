@@ -0,0 +1,142 @@
+package rsutils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics records every measurement it receives.
+type recordingMetrics struct {
+	mu             sync.Mutex
+	bytesHashed    map[string]int64
+	corruptShards  map[string]int
+	repairsOK      map[string]int
+	repairsFailed  map[string]int
+	latencyObserve map[string]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		bytesHashed:    make(map[string]int64),
+		corruptShards:  make(map[string]int),
+		repairsOK:      make(map[string]int),
+		repairsFailed:  make(map[string]int),
+		latencyObserve: make(map[string]int),
+	}
+}
+
+func (m *recordingMetrics) BytesHashed(op string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesHashed[op] += n
+}
+
+func (m *recordingMetrics) CorruptShardsFound(op string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.corruptShards[op] += n
+}
+
+func (m *recordingMetrics) RepairSucceeded(op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.repairsOK[op]++
+}
+
+func (m *recordingMetrics) RepairFailed(op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.repairsFailed[op]++
+}
+
+func (m *recordingMetrics) ObserveLatency(op string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latencyObserve[op]++
+}
+
+func TestEncodeMetrics(t *testing.T) {
+	metrics := newRecordingMetrics()
+	dataInput := CreateTMPFile(t, []byte("ABCDEFGH"))
+	var parityBuffer bytes.Buffer
+
+	_, err := Encode(dataInput, 2, []io.Writer{&parityBuffer}, WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 data shards of 4 bytes and 1 parity shard of 4 bytes
+	if metrics.bytesHashed[OpEncode] != 12 {
+		t.Errorf("Got %d bytes hashed, expected 12", metrics.bytesHashed[OpEncode])
+	}
+	if metrics.latencyObserve[OpEncode] != 1 {
+		t.Errorf("Got %d latency observations, expected 1", metrics.latencyObserve[OpEncode])
+	}
+}
+
+func TestFileDecoderMetrics(t *testing.T) {
+	metrics := newRecordingMetrics()
+	dataInput := cloneFileTmp(t, getTestFile(t, "input4_corrupt")).(*os.File)
+	parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+	md := getMetadata()
+
+	decoder, err := Open(dataInput, []*os.File{parityInput}, md, WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, md.Size)
+	_, err = decoder.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	if metrics.corruptShards[OpRead] != 1 {
+		t.Errorf("Got %d corrupt shards found, expected 1", metrics.corruptShards[OpRead])
+	}
+	if metrics.repairsOK[OpRead] != 1 || metrics.repairsFailed[OpRead] != 0 {
+		t.Errorf("Got %d successful and %d failed repairs, expected 1 and 0", metrics.repairsOK[OpRead], metrics.repairsFailed[OpRead])
+	}
+	if metrics.bytesHashed[OpRead] == 0 {
+		t.Errorf("Expected bytes hashed to be reported")
+	}
+	if metrics.latencyObserve[OpRead] != 1 {
+		t.Errorf("Got %d latency observations, expected 1", metrics.latencyObserve[OpRead])
+	}
+}
+
+func TestShardManagerMetrics(t *testing.T) {
+	metrics := newRecordingMetrics()
+	shards := getShards(t)
+	md := getMetadata()
+	for _, i := range []int{1, 2} {
+		err := corruptShard(shards[i], int(md.Size)/md.DataShards)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manager := NewShardManager(shards, md, WithMetrics(metrics))
+	if manager.CheckHealth() == nil {
+		t.Fatal("Expected CheckHealth to fail")
+	}
+	if manager.Repair() == nil {
+		t.Fatal("Expected Repair to fail")
+	}
+
+	if metrics.corruptShards[OpCheckHealth] != 2 || metrics.corruptShards[OpRepair] != 2 {
+		t.Errorf("Got corrupt shards found %v, expected 2 for check_health and repair", metrics.corruptShards)
+	}
+	if metrics.repairsFailed[OpRepair] != 1 || metrics.repairsOK[OpRepair] != 0 {
+		t.Errorf("Got %d failed and %d successful repairs, expected 1 and 0", metrics.repairsFailed[OpRepair], metrics.repairsOK[OpRepair])
+	}
+	expectedBytes := int64(len(md.Hashes)) * md.Size / int64(md.DataShards)
+	if metrics.bytesHashed[OpCheckHealth] != expectedBytes {
+		t.Errorf("Got %d bytes hashed, expected %d", metrics.bytesHashed[OpCheckHealth], expectedBytes)
+	}
+	if metrics.latencyObserve[OpCheckHealth] != 1 || metrics.latencyObserve[OpRepair] != 1 {
+		t.Errorf("Got latency observations %v, expected 1 for check_health and repair", metrics.latencyObserve)
+	}
+}
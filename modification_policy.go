@@ -0,0 +1,41 @@
+package rsutils
+
+import "errors"
+
+// ErrDataModified is returned by FileDecoder when the data file was changed
+// since it was encoded and the change should not be repaired away.
+var ErrDataModified = errors.New("Data was modified since it was encoded")
+
+// ModificationPolicy decides how FileDecoder handles data shards that no
+// longer match their hashes. Corrupt parity shards are always repaired.
+type ModificationPolicy int
+
+const (
+	// RepairModifications treats every change to the data as corruption and
+	// restores the encoded contents.
+	RepairModifications ModificationPolicy = iota
+	// ReencodeModifications treats changed data as a new version: the parity
+	// shards are re-encoded and the Metadata is updated in place.
+	ReencodeModifications
+	// RefuseModifications returns ErrDataModified whenever the data changed.
+	RefuseModifications
+	// RepairUnlessEdited restores the encoded contents like
+	// RepairModifications, unless every data shard changed while the parity
+	// is intact. That looks like an intentional edit rather than rot, so
+	// ErrDataModified is returned instead of undoing it.
+	RepairUnlessEdited
+)
+
+// looksLikeEdit reports whether every data shard changed while all parity
+// shards stayed intact. Bit rot and bad sectors hit shards independently, so
+// this pattern almost always means somebody rewrote the file on purpose.
+func looksLikeEdit(corruptShards []*CorruptShard, md *Metadata) bool {
+	corruptData := 0
+	for _, corruptShard := range corruptShards {
+		if corruptShard.index >= md.DataShards {
+			return false
+		}
+		corruptData++
+	}
+	return md.DataShards > 1 && corruptData == md.DataShards
+}
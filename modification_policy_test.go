@@ -0,0 +1,297 @@
+package rsutils
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFileDecoderModificationPolicy(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/input3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := append([]byte(nil), original...)
+	copy(edited[0:], "THE TYGER")
+	copy(edited[len(edited)-10:], "THE END!!\n")
+
+	tests := []struct {
+		name             string
+		policy           ModificationPolicy
+		dataFileName     string
+		dataContents     []byte
+		expectedErr      error
+		expectedContents []byte
+	}{
+		{"repair corruption", RepairModifications, "input4_corrupt", nil, nil, original},
+		{"repair unless edited repairs corruption", RepairUnlessEdited, "input4_corrupt", nil, nil, original},
+		{"repair unless edited refuses likely edit", RepairUnlessEdited, "", edited, ErrDataModified, edited},
+		{"refuse", RefuseModifications, "input4_corrupt", nil, ErrDataModified, nil},
+		{"reencode", ReencodeModifications, "", edited, nil, edited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dataInput *os.File
+			if tt.dataFileName != "" {
+				dataInput = cloneFileTmp(t, getTestFile(t, tt.dataFileName)).(*os.File)
+			} else {
+				dataInput = CreateTMPFile(t, tt.dataContents)
+			}
+			parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+			md := getMetadata()
+
+			decoder, err := Open(dataInput, []*os.File{parityInput}, md, WithModificationPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, md.Size)
+			_, err = decoder.ReadAt(buf, 0)
+			if err != tt.expectedErr {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+
+			if tt.expectedContents == nil {
+				return
+			}
+			contents, err := ioutil.ReadFile(dataInput.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents, tt.expectedContents) {
+				t.Errorf("Got contents:\n%s\nexpected:\n%s", contents, tt.expectedContents)
+			}
+		})
+	}
+}
+
+func TestFileDecoderModificationPolicyAppendedData(t *testing.T) {
+	// 45 bytes split into 3 shards leaves no padding in the last shard, so
+	// appending doesn't change the hash of any shard.
+	original := bytes.Repeat([]byte("ABCDEFGHIJKLMNO"), 3)
+	appended := append(append([]byte(nil), original...), "PQRST"...)
+
+	tests := []struct {
+		name             string
+		policy           ModificationPolicy
+		expectedErr      error
+		expectedContents []byte
+	}{
+		{"repair", RepairModifications, nil, original},
+		{"refuse", RefuseModifications, ErrDataModified, appended},
+		{"reencode", ReencodeModifications, nil, appended},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataInput := CreateTMPFile(t, original)
+			md, parityFiles := encodeTmp(t, dataInput, 3, 2)
+			_, err := dataInput.WriteAt([]byte("PQRST"), int64(len(original)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder, err := Open(dataInput, parityFiles, md, WithModificationPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, len(appended))
+			_, err = decoder.ReadAt(buf, 0)
+			if tt.expectedErr != nil {
+				if err != tt.expectedErr {
+					t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+				}
+			} else if err != nil && err != io.EOF {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+
+			contents, err := ioutil.ReadFile(dataInput.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents, tt.expectedContents) {
+				t.Errorf("Got contents:\n%s\nexpected:\n%s", contents, tt.expectedContents)
+			}
+			if tt.expectedErr == nil {
+				assertMetadataMatchesEncode(t, md, tt.expectedContents)
+			}
+		})
+	}
+}
+
+func TestFileDecoderReencodeUpdatesMetadata(t *testing.T) {
+	dataInput := CreateTMPFile(t, []byte("ABCDEFGH"))
+	md, parityFiles := encodeTmp(t, dataInput, 2, 1)
+	_, err := dataInput.WriteAt([]byte("xy"), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := Open(dataInput, parityFiles, md, WithModificationPolicy(ReencodeModifications))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	_, err = decoder.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(buf, []byte("ABCDEFxy")) {
+		t.Errorf("Got '%s', expected 'ABCDEFxy'", buf)
+	}
+	assertMetadataMatchesEncode(t, md, []byte("ABCDEFxy"))
+}
+
+func TestFileDecoderConcurrentReadsWhileReencoding(t *testing.T) {
+	dataInput := CreateTMPFile(t, []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+	md, parityFiles := encodeTmp(t, dataInput, 3, 2)
+	_, err := dataInput.WriteAt([]byte("xyz"), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := Open(dataInput, parityFiles, md, WithModificationPolicy(ReencodeModifications))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			buf := make([]byte, 4)
+			_, err := decoder.ReadAt(buf, off)
+			if err != nil && err != io.EOF {
+				t.Error(err)
+			}
+			_, err = decoder.Seek(-1, io.SeekEnd)
+			if err != nil {
+				t.Error(err)
+			}
+		}(int64(i * 3))
+	}
+	wg.Wait()
+	assertMetadataMatchesEncode(t, md, []byte("ABCDEFGHIJKLMNOPQRSTxyzXYZ"))
+}
+
+func TestFileDecoderReencodeStoresMetadata(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode func(t *testing.T) string
+		open   func(path string, opts ...Option) (*FileDecoder, error)
+	}{
+		{"OpenFile", func(t *testing.T) string {
+			path, _ := encodeTestFile(t, 3, 2)
+			return path
+		}, OpenFile},
+		{"OpenPath", func(t *testing.T) string {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "data")
+			err := ioutil.WriteFile(path, []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer data.Close()
+			parityFile, err := os.Create(filepath.Join(dir, "parity"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer parityFile.Close()
+			_, err = Encode(data, 3, []io.Writer{parityFile}, WithMetadataXattr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}, OpenPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.encode(t)
+			corruptFileAt(t, path, 10)
+			edited, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder, err := tt.open(path, WithModificationPolicy(ReencodeModifications))
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, len(edited))
+			_, err = decoder.ReadAt(buf, 0)
+			decoder.Close()
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+
+			// the stored Metadata describes the edited data now
+			decoder, err = tt.open(path, WithModificationPolicy(RefuseModifications))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer decoder.Close()
+			_, err = decoder.ReadAt(buf, 0)
+			if err != nil {
+				t.Fatalf("Expected nil error after reopening, got %s", err)
+			}
+			if !bytes.Equal(buf, edited) {
+				t.Errorf("Got '%s', expected '%s'", buf, edited)
+			}
+		})
+	}
+}
+
+func TestFileDecoderFailedReencodeKeepsParity(t *testing.T) {
+	dataInput := CreateTMPFile(t, []byte("ABCDEFGH"))
+	md, parityFiles := encodeTmp(t, dataInput, 2, 1)
+	originalHashes := append([]string(nil), md.Hashes...)
+	originalParity, err := ioutil.ReadFile(parityFiles[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dataInput.WriteAt([]byte("xy"), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a directory in the way of the temp file makes the re-encode fail
+	err = os.Mkdir(repairFilePath(dataInput, 2), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(repairFilePath(dataInput, 2))
+
+	decoder, err := Open(dataInput, parityFiles, md, WithModificationPolicy(ReencodeModifications))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	_, err = decoder.ReadAt(buf, 0)
+	if err == nil {
+		t.Fatal("Expected re-encoding to fail")
+	}
+
+	parity, err := ioutil.ReadFile(parityFiles[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parity, originalParity) {
+		t.Errorf("Expected the parity to be left untouched")
+	}
+	for i := range originalHashes {
+		if md.Hashes[i] != originalHashes[i] {
+			t.Errorf("Shard %d: expected hash %s to be kept, got %s", i, originalHashes[i], md.Hashes[i])
+		}
+	}
+	if journalExists(dataInput) {
+		t.Errorf("Expected the journal to be removed")
+	}
+}
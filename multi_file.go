@@ -0,0 +1,143 @@
+package rsutils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// MultiFile presents several files as one virtual file, their contents
+// concatenated in order. It implements ReadAtWriteAtSeeker, so a group of
+// files of any sizes can be split with SplitIntoPaddedChunks and protected by a
+// single set of parity shards; repairs are written to whichever files hold the
+// repaired bytes.
+// The sizes of the files are taken when the MultiFile is created and must not
+// change while it is in use. Writes can't extend it past its size.
+type MultiFile struct {
+	files []*os.File
+	// starting offset of each file within the MultiFile
+	offsets []int64
+	sizes   []int64
+	size    int64
+	// position of the next Read or Write
+	position int64
+}
+
+// NewMultiFile returns a MultiFile concatenating files.
+func NewMultiFile(files ...*os.File) (*MultiFile, error) {
+	m := &MultiFile{
+		files:   files,
+		offsets: make([]int64, len(files)),
+		sizes:   make([]int64, len(files)),
+	}
+	for i, f := range files {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		m.offsets[i] = m.size
+		m.sizes[i] = fi.Size()
+		m.size += fi.Size()
+	}
+	return m, nil
+}
+
+// Size returns the total size of the files.
+func (m *MultiFile) Size() int64 {
+	return m.size
+}
+
+// fileAt returns the index of the file holding the byte at off.
+func (m *MultiFile) fileAt(off int64) int {
+	return sort.Search(len(m.files), func(i int) bool {
+		return m.offsets[i]+m.sizes[i] > off
+	})
+}
+
+// span calls fn with the part of p that falls into each file, starting at off,
+// until fn fails or p or the MultiFile ends. It returns the number of bytes
+// handled.
+func (m *MultiFile) span(p []byte, off int64, fn func(f *os.File, p []byte, off int64) (int, error)) (int, error) {
+	n := 0
+	for n < len(p) && off+int64(n) < m.size {
+		pos := off + int64(n)
+		i := m.fileAt(pos)
+		fileOff := pos - m.offsets[i]
+		end := len(p)
+		if left := m.sizes[i] - fileOff; int64(end-n) > left {
+			end = n + int(left)
+		}
+		done, err := fn(m.files[i], p[n:end], fileOff)
+		n += done
+		if err == io.EOF {
+			return n, fmt.Errorf("File %s is shorter than %d bytes", m.files[i].Name(), m.sizes[i])
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadAt reads len(p) bytes starting at offset off of the concatenated files.
+// It may be called concurrently.
+func (m *MultiFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Requested position %d is negative", off)
+	}
+	n, err := m.span(p, off, (*os.File).ReadAt)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// WriteAt writes p starting at offset off of the concatenated files. It may be
+// called concurrently.
+func (m *MultiFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Requested position %d is negative", off)
+	}
+	if off+int64(len(p)) > m.size {
+		return 0, fmt.Errorf("Cannot write %d bytes at offset %d: size is %d", len(p), off, m.size)
+	}
+	return m.span(p, off, (*os.File).WriteAt)
+}
+
+// Read reads from the current position.
+func (m *MultiFile) Read(p []byte) (int, error) {
+	n, err := m.ReadAt(p, m.position)
+	m.position += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Write writes at the current position.
+func (m *MultiFile) Write(p []byte) (int, error) {
+	n, err := m.WriteAt(p, m.position)
+	m.position += int64(n)
+	return n, err
+}
+
+// Seek sets the position of the next Read or Write.
+func (m *MultiFile) Seek(offset int64, whence int) (int64, error) {
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = m.position + offset
+	case io.SeekEnd:
+		position = m.size + offset
+	default:
+		return m.position, fmt.Errorf("Got %d, expected one of: io.SeekStart, io.SeekCurrent, io.SeekEnd", whence)
+	}
+	if position < 0 {
+		return m.position, fmt.Errorf("Requested position %d is negative", position)
+	}
+	m.position = position
+	return m.position, nil
+}
@@ -0,0 +1,148 @@
+package rsutils
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func createMultiFile(t *testing.T, contents ...string) (*MultiFile, []*os.File) {
+	files := make([]*os.File, len(contents))
+	for i := range contents {
+		files[i] = CreateTMPFile(t, []byte(contents[i]))
+	}
+	m, err := NewMultiFile(files...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, files
+}
+
+func TestMultiFileReadAt(t *testing.T) {
+	m, _ := createMultiFile(t, "ABC", "", "DEFGH", "I")
+	if m.Size() != 9 {
+		t.Fatalf("Got size %d, expected 9", m.Size())
+	}
+
+	tests := []struct {
+		name          string
+		off           int64
+		bufLen        int
+		expectedBytes string
+		expectedErr   error
+	}{
+		{"within a file", 4, 3, "EFG", nil},
+		{"across files", 1, 7, "BCDEFGH", nil},
+		{"everything", 0, 9, "ABCDEFGHI", nil},
+		{"past the end", 7, 4, "HI", io.EOF},
+		{"at the end", 9, 1, "", io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, tt.bufLen)
+			n, err := m.ReadAt(buf, tt.off)
+			if err != tt.expectedErr {
+				t.Errorf("Got error %v, expected %v", err, tt.expectedErr)
+			}
+			if string(buf[:n]) != tt.expectedBytes {
+				t.Errorf("Got '%s', expected '%s'", buf[:n], tt.expectedBytes)
+			}
+		})
+	}
+}
+
+func TestMultiFileWriteAt(t *testing.T) {
+	m, files := createMultiFile(t, "ABC", "DEFGH", "I")
+
+	n, err := m.WriteAt([]byte("xyz"), 2)
+	if err != nil || n != 3 {
+		t.Fatalf("Got %d bytes written and error %v, expected 3 and nil", n, err)
+	}
+	expectedErrMsg := "Cannot write 3 bytes at offset 7: size is 9"
+	_, err = m.WriteAt([]byte("xyz"), 7)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+
+	for i, expected := range []string{"ABx", "yzFGH", "I"} {
+		contents, err := ioutil.ReadFile(files[i].Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(contents) != expected {
+			t.Errorf("Got '%s' in file %d, expected '%s'", contents, i, expected)
+		}
+	}
+}
+
+func TestMultiFileReadSeek(t *testing.T) {
+	m, _ := createMultiFile(t, "ABC", "DEFGH", "I")
+
+	pos, err := m.Seek(-4, io.SeekEnd)
+	if err != nil || pos != 5 {
+		t.Fatalf("Got position %d and error %v, expected 5 and nil", pos, err)
+	}
+	contents, err := ioutil.ReadAll(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "FGHI" {
+		t.Errorf("Got '%s', expected 'FGHI'", contents)
+	}
+}
+
+func TestMultiFileRepair(t *testing.T) {
+	original := []string{"The Tyger", "", "Tyger Tyger, burning bright,\nIn the forests of the night;", "?", "What immortal hand or eye,\nCould frame thy fearful symmetry?\n"}
+	m, files := createMultiFile(t, original...)
+	dataShards := 4
+
+	chunks := SplitIntoPaddedChunks(m, m.Size(), dataShards)
+	chunkReaders := make([]io.Reader, len(chunks))
+	for i := range chunks {
+		chunkReaders[i] = chunks[i]
+	}
+	parityFiles := []*os.File{CreateTMPFile(t, []byte{}), CreateTMPFile(t, []byte{})}
+	md, err := NewShardCreator(chunkReaders, m.Size(), dataShards, 2).Encode([]io.Writer{parityFiles[0], parityFiles[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the first and the last file, in different shards.
+	for _, i := range []int{0, 4} {
+		_, err = files[i].WriteAt([]byte("##"), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	shards := make([]io.ReadWriteSeeker, 0, dataShards+2)
+	for _, chunk := range chunks {
+		_, err = chunk.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, chunk)
+	}
+	for _, parityFile := range parityFiles {
+		_, err = parityFile.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, parityFile)
+	}
+
+	err = NewShardManager(shards, md).Repair()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	for i := range files {
+		contents, err := ioutil.ReadFile(files[i].Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(contents, []byte(original[i])) {
+			t.Errorf("Got '%s' in file %d, expected '%s'", contents, i, original[i])
+		}
+	}
+}
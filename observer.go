@@ -0,0 +1,80 @@
+package rsutils
+
+// ShardEvent describes corrupt shards and what is being done about them.
+type ShardEvent struct {
+	// Op is the operation the shards were found corrupt in, one of the Op
+	// constants.
+	Op string
+	// Shards are the indexes of the corrupt shards, data shards first.
+	Shards []int
+	// ExpectedHashes are the hashes of the shards recorded in the Metadata,
+	// in the same order as Shards.
+	ExpectedHashes []string
+	// ActualHashes are the hashes the shards were found to have, in the same
+	// order as Shards, or empty strings for shards that couldn't be read.
+	ActualHashes []string
+	// Bytes is the total size of the corrupt shards.
+	Bytes int64
+	// Err is the reason the repair failed. It is only set for OnRepairFailed.
+	Err error
+}
+
+// Observer is notified whenever corrupt shards are found and repaired, set with
+// WithObserver. Unlike Metrics it receives the details of every event, e.g. to
+// raise alerts or keep an audit log. Callbacks are made synchronously, while
+// the shards are locked, so they should return quickly. Implementations must
+// be safe for concurrent use.
+type Observer interface {
+	// OnCorruptionDetected is called when verification finds corrupt shards.
+	OnCorruptionDetected(event ShardEvent)
+	// OnRepairStarted is called before corrupt shards are repaired.
+	OnRepairStarted(event ShardEvent)
+	// OnRepairCompleted is called after corrupt shards were repaired.
+	OnRepairCompleted(event ShardEvent)
+	// OnRepairFailed is called after an attempt to repair corrupt shards
+	// failed, with event.Err set to the reason.
+	OnRepairFailed(event ShardEvent)
+}
+
+// BaseObserver implements Observer with callbacks that do nothing. Embed it to
+// only implement the callbacks you are interested in.
+type BaseObserver struct{}
+
+func (BaseObserver) OnCorruptionDetected(event ShardEvent) {}
+func (BaseObserver) OnRepairStarted(event ShardEvent)      {}
+func (BaseObserver) OnRepairCompleted(event ShardEvent)    {}
+func (BaseObserver) OnRepairFailed(event ShardEvent)       {}
+
+// newShardEvent describes corruptShards of shardSize bytes each.
+func newShardEvent(op string, corruptShards []*CorruptShard, md *Metadata, shardSize int64) ShardEvent {
+	event := ShardEvent{
+		Op:             op,
+		Shards:         shardIndexes(corruptShards),
+		ExpectedHashes: make([]string, len(corruptShards)),
+		ActualHashes:   make([]string, len(corruptShards)),
+		Bytes:          shardSize * int64(len(corruptShards)),
+	}
+	for i, corruptShard := range corruptShards {
+		event.ExpectedHashes[i] = md.Hashes[corruptShard.index]
+		event.ActualHashes[i] = corruptShard.hash
+	}
+	return event
+}
+
+// reportRepairEvent notifies o of the outcome of a repair attempt.
+func reportRepairEvent(o Observer, event ShardEvent, err error) {
+	if err != nil {
+		event.Err = err
+		o.OnRepairFailed(event)
+	} else {
+		o.OnRepairCompleted(event)
+	}
+}
+
+func shardIndexes(corruptShards []*CorruptShard) []int {
+	indexes := make([]int, len(corruptShards))
+	for i, corruptShard := range corruptShards {
+		indexes[i] = corruptShard.index
+	}
+	return indexes
+}
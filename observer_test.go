@@ -0,0 +1,105 @@
+package rsutils
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recordingObserver records the events it is notified of, prefixed with the
+// name of the callback.
+type recordingObserver struct {
+	BaseObserver
+	mu     sync.Mutex
+	calls  []string
+	events []ShardEvent
+}
+
+func (o *recordingObserver) record(call string, event ShardEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.calls = append(o.calls, call)
+	o.events = append(o.events, event)
+}
+
+func (o *recordingObserver) OnCorruptionDetected(event ShardEvent) {
+	o.record("detected", event)
+}
+
+func (o *recordingObserver) OnRepairStarted(event ShardEvent) {
+	o.record("started", event)
+}
+
+func (o *recordingObserver) OnRepairCompleted(event ShardEvent) {
+	o.record("completed", event)
+}
+
+func (o *recordingObserver) OnRepairFailed(event ShardEvent) {
+	o.record("failed", event)
+}
+
+func TestFileDecoderObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	dataInput := cloneFileTmp(t, getTestFile(t, "input4_corrupt")).(*os.File)
+	parityInput := cloneFileTmp(t, getTestFile(t, "parity1")).(*os.File)
+	md := getMetadata()
+
+	decoder, err := Open(dataInput, []*os.File{parityInput}, md, WithObserver(observer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, md.Size)
+	_, err = decoder.ReadAt(buf, 0)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	expectedCalls := []string{"detected", "started", "completed"}
+	if !reflect.DeepEqual(observer.calls, expectedCalls) {
+		t.Fatalf("Got calls %v, expected %v", observer.calls, expectedCalls)
+	}
+	event := observer.events[0]
+	if event.Op != OpRead || len(event.Shards) != 1 {
+		t.Fatalf("Got event %+v, expected one corrupt shard found while reading", event)
+	}
+	if event.ExpectedHashes[0] != md.Hashes[event.Shards[0]] || event.ActualHashes[0] == event.ExpectedHashes[0] {
+		t.Errorf("Got expected hash %s and actual hash %s for shard %d", event.ExpectedHashes[0], event.ActualHashes[0], event.Shards[0])
+	}
+	if event.Bytes != md.Size/int64(md.DataShards) {
+		t.Errorf("Got %d bytes, expected %d", event.Bytes, md.Size/int64(md.DataShards))
+	}
+	if !reflect.DeepEqual(observer.events[2], event) {
+		t.Errorf("Got completion event %+v, expected %+v", observer.events[2], event)
+	}
+}
+
+func TestShardManagerObserverRepairFailed(t *testing.T) {
+	observer := &recordingObserver{}
+	shards := getShards(t)
+	md := getMetadata()
+	for _, i := range []int{1, 2} {
+		err := corruptShard(shards[i], int(md.Size)/md.DataShards)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manager := NewShardManager(shards, md, WithObserver(observer))
+	err := manager.Repair()
+	if err == nil {
+		t.Fatal("Expected Repair to fail")
+	}
+
+	expectedCalls := []string{"detected", "started", "failed"}
+	if !reflect.DeepEqual(observer.calls, expectedCalls) {
+		t.Fatalf("Got calls %v, expected %v", observer.calls, expectedCalls)
+	}
+	failed := observer.events[2]
+	if !reflect.DeepEqual(failed.Shards, []int{1, 2}) || failed.Op != OpRepair {
+		t.Errorf("Got event %+v, expected shards [1 2] during repair", failed)
+	}
+	if failed.Err != err {
+		t.Errorf("Got event error %v, expected %v", failed.Err, err)
+	}
+}
@@ -0,0 +1,182 @@
+package rsutils
+
+import "time"
+
+// Option configures the behaviour of Encode, Open, NewShardCreator and
+// NewShardManager.
+// Options that don't apply to a given call are ignored.
+type Option func(*options)
+
+type options struct {
+	lockTimeout time.Duration
+	detector    ChangeDetector
+	policy      ModificationPolicy
+
+	metadataXattr bool
+	metrics       Metrics
+	observer      Observer
+
+	matrix            Matrix
+	matrixSet         bool
+	streamBlockSize   int
+	concurrentStreams bool
+
+	localGroupSize int
+
+	fallback    FallbackDestination
+	readTimeout time.Duration
+
+	degradedReads bool
+}
+
+func newOptions(opts []Option) options {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.detector == nil {
+		o.detector = NewStatChangeDetector()
+	}
+	if o.metrics == nil {
+		o.metrics = nopMetrics{}
+	}
+	if o.observer == nil {
+		o.observer = BaseObserver{}
+	}
+	return o
+}
+
+// WithLockTimeout sets how long to wait for the advisory locks taken on data
+// and parity shards while verifying (shared) and repairing (exclusive) them.
+// ErrLockTimeout is returned if the locks can't be acquired in time.
+// The default, 0, waits indefinitely.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = timeout
+	}
+}
+
+// WithChangeDetector sets the strategy FileDecoder uses to decide whether the
+// data and parity files have to be verified again before a read.
+// The default is NewStatChangeDetector.
+func WithChangeDetector(detector ChangeDetector) Option {
+	return func(o *options) {
+		o.detector = detector
+	}
+}
+
+// WithModificationPolicy sets what FileDecoder does when the data file no
+// longer matches its hashes. The default is RepairModifications.
+func WithModificationPolicy(policy ModificationPolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// WithMetadataXattr makes Encode store the Metadata and the paths of the
+// parity files in the user.rsutils.metadata extended attribute of the data
+// file, or in a "<data file>.rsmeta" sidecar file when extended attributes
+// are not supported. Every parity writer has to be an *os.File.
+func WithMetadataXattr() Option {
+	return func(o *options) {
+		o.metadataXattr = true
+	}
+}
+
+// WithMetrics reports measurements of encoding, verification and repair to m.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithObserver notifies observer whenever Open or NewShardManager find and
+// repair corrupt shards.
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		o.observer = observer
+	}
+}
+
+// WithMatrix sets the encoding matrix used by Encode and ShardCreator.Encode,
+// which is recorded in the returned Metadata. Decoding always uses the matrix
+// recorded in the Metadata; passing WithMatrix to Open or NewShardManager makes
+// them refuse to repair shards encoded with a different one.
+// The default is VandermondeMatrix.
+func WithMatrix(matrix Matrix) Option {
+	return func(o *options) {
+		o.matrix = matrix
+		o.matrixSet = true
+	}
+}
+
+// WithStreamBlockSize sets the size of the blocks shards are read in while
+// encoding and reconstructing them. It does not change the encoding.
+// The default is 4 MiB.
+func WithStreamBlockSize(size int) Option {
+	return func(o *options) {
+		o.streamBlockSize = size
+	}
+}
+
+// WithConcurrentStreams makes encoding and reconstruction read and write all
+// shards concurrently, which is faster when they are on different disks.
+// It does not change the encoding.
+func WithConcurrentStreams() Option {
+	return func(o *options) {
+		o.concurrentStreams = true
+	}
+}
+
+// WithLocalGroups makes ShardCreator.Encode split the data shards into groups of
+// size shards and compute a local parity shard for each group in addition to
+// the global parity shards. ShardManager.Repair then repairs a single broken
+// shard in a group by reading only the rest of its group, instead of
+// DataShards shards. The local parity shards are written to the parity
+// writers following the global ones; Metadata.LocalGroups describes the groups.
+// Local groups are only supported by ShardCreator and ShardManager: Encode,
+// EncodeFile and Split return an error when the option is set, and Open,
+// OpenFile, OpenPath, NewUpdater and NewProtectedFile refuse Metadata with
+// LocalGroups, so files protected by FileDecoder only have global parity.
+func WithLocalGroups(size int) Option {
+	return func(o *options) {
+		o.localGroupSize = size
+	}
+}
+
+// WithFallbackDestination makes repairs write a reconstructed shard to
+// fallback when it can't be written back over the original. The original shard
+// is left as it is and still corrupt, so the repair returns ErrShardRelocated
+// once everything else is repaired, and later repairs don't write the shard to
+// fallback again. Reads rebuild its data in memory.
+func WithFallbackDestination(fallback FallbackDestination) Option {
+	return func(o *options) {
+		o.fallback = fallback
+	}
+}
+
+// WithReadTimeout makes NewShardManager give up on a shard that takes longer
+// than timeout to get through a pass over it, e.g. hashing or reading it whole,
+// or to answer an attempt to lock it, e.g. because it is on a hung network
+// mount. A lock held by somebody else is still waited for as WithLockTimeout
+// says. The shard is then treated as unavailable: it is reported as corrupt,
+// Read and ReadRange rebuild its data from the other shards, and Repair
+// reconstructs it and writes it to the FallbackDestination.
+// The default, 0, waits indefinitely.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.readTimeout = timeout
+	}
+}
+
+// WithDegradedReads makes FileDecoder rebuild the data of corrupt data shards
+// in memory, from the other shards, when it is read, instead of repairing the
+// files. Nothing is written, so it works on read-only files; Repair still
+// repairs them. FileDecoder.DegradedReads counts the reads served this way.
+// The ModificationPolicy still applies: changed data is only rebuilt if the
+// policy would repair it, and ReencodeModifications re-encodes as usual.
+func WithDegradedReads() Option {
+	return func(o *options) {
+		o.degradedReads = true
+	}
+}
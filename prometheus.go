@@ -0,0 +1,162 @@
+package rsutils
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histogram
+// buckets exposed by PrometheusMetrics.
+var latencyBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type repairKey struct {
+	op     string
+	result string
+}
+
+// PrometheusMetrics is a Metrics implementation that keeps counters and
+// latency histograms in memory and serves them in the Prometheus text
+// exposition format. It is an http.Handler, meant to be mounted on /metrics:
+//
+//	m := rsutils.NewPrometheusMetrics()
+//	http.Handle("/metrics", m)
+//	decoder, err := rsutils.Open(data, parityFiles, md, rsutils.WithMetrics(m))
+type PrometheusMetrics struct {
+	mu            sync.Mutex
+	bytesHashed   map[string]int64
+	corruptShards map[string]int64
+	repairs       map[repairKey]int64
+	latencies     map[string]*histogram
+}
+
+// NewPrometheusMetrics returns an empty PrometheusMetrics.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		bytesHashed:   make(map[string]int64),
+		corruptShards: make(map[string]int64),
+		repairs:       make(map[repairKey]int64),
+		latencies:     make(map[string]*histogram),
+	}
+}
+
+func (m *PrometheusMetrics) BytesHashed(op string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesHashed[op] += n
+}
+
+func (m *PrometheusMetrics) CorruptShardsFound(op string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.corruptShards[op] += int64(n)
+}
+
+func (m *PrometheusMetrics) RepairSucceeded(op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.repairs[repairKey{op, "success"}]++
+}
+
+func (m *PrometheusMetrics) RepairFailed(op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.repairs[repairKey{op, "failure"}]++
+}
+
+func (m *PrometheusMetrics) ObserveLatency(op string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.latencies[op]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[op] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ServeHTTP writes the current values of all metrics in the Prometheus text
+// exposition format.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the current values of all metrics to w in the Prometheus text
+// exposition format. It returns the first error returned by w.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pw := &countingWriter{w: w}
+
+	fmt.Fprintln(pw, "# HELP rsutils_bytes_hashed_total Shard bytes hashed.")
+	fmt.Fprintln(pw, "# TYPE rsutils_bytes_hashed_total counter")
+	for _, op := range sortedKeys(m.bytesHashed) {
+		fmt.Fprintf(pw, "rsutils_bytes_hashed_total{op=%q} %d\n", op, m.bytesHashed[op])
+	}
+
+	fmt.Fprintln(pw, "# HELP rsutils_corrupt_shards_total Corrupt shards found.")
+	fmt.Fprintln(pw, "# TYPE rsutils_corrupt_shards_total counter")
+	for _, op := range sortedKeys(m.corruptShards) {
+		fmt.Fprintf(pw, "rsutils_corrupt_shards_total{op=%q} %d\n", op, m.corruptShards[op])
+	}
+
+	fmt.Fprintln(pw, "# HELP rsutils_repairs_total Repair attempts by result.")
+	fmt.Fprintln(pw, "# TYPE rsutils_repairs_total counter")
+	repairKeys := make([]repairKey, 0, len(m.repairs))
+	for key := range m.repairs {
+		repairKeys = append(repairKeys, key)
+	}
+	sort.Slice(repairKeys, func(i, j int) bool {
+		if repairKeys[i].op != repairKeys[j].op {
+			return repairKeys[i].op < repairKeys[j].op
+		}
+		return repairKeys[i].result < repairKeys[j].result
+	})
+	for _, key := range repairKeys {
+		fmt.Fprintf(pw, "rsutils_repairs_total{op=%q,result=%q} %d\n", key.op, key.result, m.repairs[key])
+	}
+
+	fmt.Fprintln(pw, "# HELP rsutils_operation_duration_seconds Duration of operations.")
+	fmt.Fprintln(pw, "# TYPE rsutils_operation_duration_seconds histogram")
+	ops := make([]string, 0, len(m.latencies))
+	for op := range m.latencies {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		h := m.latencies[op]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(pw, "rsutils_operation_duration_seconds_bucket{op=%q,le=\"%g\"} %d\n", op, bound, h.counts[i])
+		}
+		fmt.Fprintf(pw, "rsutils_operation_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, h.count)
+		fmt.Fprintf(pw, "rsutils_operation_duration_seconds_sum{op=%q} %g\n", op, h.sum)
+		fmt.Fprintf(pw, "rsutils_operation_duration_seconds_count{op=%q} %d\n", op, h.count)
+	}
+	return pw.n, pw.err
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package rsutils
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetricsHandler(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.BytesHashed(OpEncode, 100)
+	m.BytesHashed(OpEncode, 28)
+	m.CorruptShardsFound(OpRead, 2)
+	m.RepairSucceeded(OpRead)
+	m.RepairFailed(OpRepair)
+	m.ObserveLatency(OpCheckHealth, 20*time.Millisecond)
+	m.ObserveLatency(OpCheckHealth, 2*time.Second)
+
+	server := httptest.NewServer(m)
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Got content type '%s', expected text/plain", contentType)
+	}
+	expectedLines := []string{
+		"# TYPE rsutils_bytes_hashed_total counter",
+		`rsutils_bytes_hashed_total{op="encode"} 128`,
+		`rsutils_corrupt_shards_total{op="read"} 2`,
+		`rsutils_repairs_total{op="read",result="success"} 1`,
+		`rsutils_repairs_total{op="repair",result="failure"} 1`,
+		"# TYPE rsutils_operation_duration_seconds histogram",
+		`rsutils_operation_duration_seconds_bucket{op="check_health",le="0.01"} 0`,
+		`rsutils_operation_duration_seconds_bucket{op="check_health",le="0.05"} 1`,
+		`rsutils_operation_duration_seconds_bucket{op="check_health",le="5"} 2`,
+		`rsutils_operation_duration_seconds_bucket{op="check_health",le="+Inf"} 2`,
+		`rsutils_operation_duration_seconds_sum{op="check_health"} 2.02`,
+		`rsutils_operation_duration_seconds_count{op="check_health"} 2`,
+	}
+	lines := strings.Split(string(body), "\n")
+	for _, expected := range expectedLines {
+		found := false
+		for _, line := range lines {
+			if line == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected line '%s' in:\n%s", expected, body)
+		}
+	}
+}
+
+// failingWriter fails every write after the first n bytes.
+type failingWriter struct {
+	n int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errWriteFailed
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestPrometheusMetricsWriteToReturnsWriteError(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.BytesHashed(OpEncode, 100)
+
+	n, err := m.WriteTo(&failingWriter{n: 10})
+	if err != errWriteFailed {
+		t.Errorf("Got error %v, expected %v", err, errWriteFailed)
+	}
+	if n != 10 {
+		t.Errorf("Got %d bytes written, expected 10", n)
+	}
+}
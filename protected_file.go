@@ -0,0 +1,268 @@
+package rsutils
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ProtectedFile is a writable Reed-Solomon-protected file. It can be used much
+// like an *os.File: writes inside the encoded size update parity incrementally,
+// while writes that grow the file and calls to Truncate mark the parity for a
+// full re-encode. Parity shards and Metadata are consistent after every Sync.
+type ProtectedFile struct {
+	data        *os.File
+	parityFiles []*os.File
+	md          *Metadata
+	updater     *Updater
+	offset      int64
+	dirtyShards map[int]bool
+	reencode    bool
+}
+
+// NewProtectedFile accepts a data file and parity files, both opened for
+// reading and writing, and the Metadata returned by Encode. The Metadata is
+// updated in place on every Sync.
+func NewProtectedFile(data *os.File, parityFiles []*os.File, md *Metadata) (*ProtectedFile, error) {
+	updater, err := NewUpdater(data, parityFiles, md)
+	if err != nil {
+		return nil, err
+	}
+	return &ProtectedFile{
+		data:        data,
+		parityFiles: parityFiles,
+		md:          md,
+		updater:     updater,
+		dirtyShards: make(map[int]bool),
+	}, nil
+}
+
+// Name returns the name of the underlying data file.
+func (pf *ProtectedFile) Name() string {
+	return pf.data.Name()
+}
+
+// Metadata returns the Metadata describing the file as of the last Sync.
+func (pf *ProtectedFile) Metadata() *Metadata {
+	return pf.md
+}
+
+func (pf *ProtectedFile) Read(p []byte) (int, error) {
+	n, err := pf.ReadAt(p, pf.offset)
+	pf.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (pf *ProtectedFile) ReadAt(p []byte, off int64) (int, error) {
+	return pf.data.ReadAt(p, off)
+}
+
+func (pf *ProtectedFile) Write(p []byte) (int, error) {
+	n, err := pf.WriteAt(p, pf.offset)
+	pf.offset += int64(n)
+	return n, err
+}
+
+// WriteAt writes p at offset off. The part of p that falls inside the encoded
+// size updates the parity shards right away, journaled like Updater.WriteAt so
+// it is rolled back if it fails; anything past it is written to the data file
+// only and the parity shards are re-encoded on the next Sync.
+func (pf *ProtectedFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Cannot write at negative offset %d", off)
+	}
+	if pf.reencode {
+		return pf.data.WriteAt(p, off)
+	}
+
+	inside := int64(0)
+	if off < pf.md.Size {
+		inside = pf.md.Size - off
+		if inside > int64(len(p)) {
+			inside = int64(len(p))
+		}
+	}
+	if inside > 0 {
+		changedShards, err := pf.updater.journaledWrite(p[:inside], off, nil)
+		if err != nil {
+			return 0, err
+		}
+		for _, i := range changedShards {
+			pf.dirtyShards[i] = true
+		}
+	}
+	if inside == int64(len(p)) {
+		return len(p), nil
+	}
+
+	pf.reencode = true
+	n, err := pf.data.WriteAt(p[inside:], off+inside)
+	return int(inside) + n, err
+}
+
+func (pf *ProtectedFile) Seek(offset int64, whence int) (int64, error) {
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = pf.offset + offset
+	case io.SeekEnd:
+		fstat, err := pf.data.Stat()
+		if err != nil {
+			return pf.offset, err
+		}
+		position = fstat.Size() + offset
+	default:
+		return pf.offset, fmt.Errorf("Got %d, expected one of: io.SeekStart, io.SeekCurrent, io.SeekEnd", whence)
+	}
+	if position < 0 {
+		return pf.offset, fmt.Errorf("Requested position %d is negative", position)
+	}
+	pf.offset = position
+	return pf.offset, nil
+}
+
+// Truncate changes the size of the data file. The parity shards are
+// re-encoded on the next Sync.
+func (pf *ProtectedFile) Truncate(size int64) error {
+	err := pf.data.Truncate(size)
+	if err != nil {
+		return err
+	}
+	if size != pf.md.Size {
+		pf.reencode = true
+	}
+	return nil
+}
+
+// Sync brings the parity shards and Metadata up to date with the data file and
+// commits the data and parity files to stable storage.
+func (pf *ProtectedFile) Sync() error {
+	if pf.reencode {
+		err := reencode(pf.data, pf.parityFiles, pf.md, newOptions(nil), nil)
+		if err != nil {
+			return err
+		}
+		updater, err := NewUpdater(pf.data, pf.parityFiles, pf.md)
+		if err != nil {
+			return err
+		}
+		pf.updater = updater
+		pf.reencode = false
+	} else if len(pf.dirtyShards) > 0 {
+		changedShards := make([]int, 0, len(pf.dirtyShards))
+		for i := range pf.dirtyShards {
+			changedShards = append(changedShards, i)
+		}
+		err := pf.updater.refreshHashes(changedShards)
+		if err != nil {
+			return err
+		}
+	}
+	pf.dirtyShards = make(map[int]bool)
+
+	err := pf.data.Sync()
+	if err != nil {
+		return err
+	}
+	for _, parityFile := range pf.parityFiles {
+		err := parityFile.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close syncs the file and closes the data and parity files.
+func (pf *ProtectedFile) Close() error {
+	syncErr := pf.Sync()
+	err := pf.data.Close()
+	for _, parityFile := range pf.parityFiles {
+		if closeErr := parityFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if syncErr != nil {
+		return syncErr
+	}
+	return err
+}
+
+// reencode rebuilds the parity shards of data from scratch and replaces the
+// contents of md with the resulting Metadata. The new parity is encoded into
+// temp files next to data and copied over the old parity through the repair
+// journal, so a failed re-encode leaves the old parity untouched and one
+// interrupted by a crash is rolled back by Open. The matrix of md is kept; o
+// supplies everything else, e.g. the stream block size and Metrics. Parity
+// shards that can only be written to the FallbackDestination are recorded in
+// relocated, and ErrShardRelocated is returned once md is updated.
+func reencode(data *os.File, parityFiles []*os.File, md *Metadata, o options, relocated relocatedShards) error {
+	journal := &repairJournal{Shards: make([]journalEntry, 0, len(parityFiles))}
+	for i := range parityFiles {
+		index := md.DataShards + i
+		journal.Shards = append(journal.Shards, journalEntry{Index: index, TempFile: repairFilePath(data, index)})
+		// The parity changes, so a copy in the fallback destination is
+		// out of date.
+		delete(relocated, index)
+	}
+	err := writeJournal(journalPath(data), journal)
+	if err != nil {
+		return err
+	}
+	tempFiles := make([]*os.File, 0, len(parityFiles))
+	defer func() {
+		for _, tempFile := range tempFiles {
+			tempFile.Close()
+		}
+	}()
+
+	parityWriters := make([]io.Writer, len(parityFiles))
+	for i, entry := range journal.Shards {
+		tempFile, err := os.OpenFile(entry.TempFile, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if err != nil {
+			rollbackJournal(data, journal)
+			return fmt.Errorf("Error creating parity file: %s", err)
+		}
+		tempFiles = append(tempFiles, tempFile)
+		parityWriters[i] = tempFile
+	}
+	o.matrix = md.Matrix
+	newMd, err := encode(OpEncode, data, md.DataShards, nil, parityWriters, o)
+	if err != nil {
+		rollbackJournal(data, journal)
+		return err
+	}
+	for i, tempFile := range tempFiles {
+		err := tempFile.Sync()
+		if err != nil {
+			rollbackJournal(data, journal)
+			return fmt.Errorf("Error syncing parity file: %s", err)
+		}
+		journal.Shards[i].Hash = newMd.Hashes[journal.Shards[i].Index]
+	}
+
+	err = writeJournal(journalPath(data), journal)
+	if err != nil {
+		rollbackJournal(data, journal)
+		return err
+	}
+	applyErr := applyJournal(data, parityFiles, newMd, o, relocated, journal)
+	if applyErr != nil && applyErr != ErrShardRelocated {
+		return applyErr
+	}
+	// The data may have shrunk, leaving the old parity longer than the new.
+	chunkSize := paddedChunkSize(newMd.Size, newMd.DataShards)
+	for _, parityFile := range parityFiles {
+		err := truncateToSize(parityFile, chunkSize)
+		if err != nil {
+			return err
+		}
+	}
+	*md = *newMd
+	return applyErr
+}
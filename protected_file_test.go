@@ -0,0 +1,171 @@
+package rsutils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func assertMetadataMatchesEncode(t *testing.T, md *Metadata, contents []byte) {
+	expectedFile := CreateTMPFile(t, contents)
+	expectedMd, _ := encodeTmp(t, expectedFile, md.DataShards, md.ParityShards)
+	if md.Size != expectedMd.Size {
+		t.Errorf("Got md.Size %d, expected %d", md.Size, expectedMd.Size)
+	}
+	for i := range expectedMd.Hashes {
+		if md.Hashes[i] != expectedMd.Hashes[i] {
+			t.Errorf("Shard %d: got hash %s, expected %s", i, md.Hashes[i], expectedMd.Hashes[i])
+		}
+	}
+}
+
+func TestProtectedFileWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    func(pf *ProtectedFile) error
+		expected []byte
+	}{
+		{"overwrite", func(pf *ProtectedFile) error {
+			_, err := pf.WriteAt([]byte("xy"), 3)
+			return err
+		}, []byte("ABCxyFGH")},
+		{"append", func(pf *ProtectedFile) error {
+			_, err := pf.Seek(0, io.SeekEnd)
+			if err != nil {
+				return err
+			}
+			_, err = pf.Write([]byte("IJK"))
+			return err
+		}, []byte("ABCDEFGHIJK")},
+		{"overwrite past end", func(pf *ProtectedFile) error {
+			_, err := pf.WriteAt([]byte("xyz"), 6)
+			return err
+		}, []byte("ABCDEFxyz")},
+		{"truncate", func(pf *ProtectedFile) error {
+			return pf.Truncate(5)
+		}, []byte("ABCDE")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataFile := CreateTMPFile(t, []byte("ABCDEFGH"))
+			md, parityFiles := encodeTmp(t, dataFile, 2, 1)
+			pf, err := NewProtectedFile(dataFile, parityFiles, md)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.write(pf)
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			err = pf.Sync()
+			if err != nil {
+				t.Fatalf("Expected nil error on Sync, got %s", err)
+			}
+
+			contents, err := ioutil.ReadFile(dataFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents, tt.expected) {
+				t.Errorf("Got contents '%s', expected '%s'", contents, tt.expected)
+			}
+			assertMetadataMatchesEncode(t, pf.Metadata(), tt.expected)
+		})
+	}
+}
+
+func TestProtectedFileDecodesAfterSync(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDEFGH"))
+	md, parityFiles := encodeTmp(t, dataFile, 2, 1)
+	pf, err := NewProtectedFile(dataFile, parityFiles, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pf.WriteAt([]byte("abcdefghij"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pf.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = corruptShard(dataFile, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, parityFile := range parityFiles {
+		_, err = parityFile.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	decoder, err := Open(dataFile, parityFiles, pf.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte("ABabcdefghij")
+	buf := make([]byte, len(expected))
+	_, err = decoder.Read(buf)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("Got '%s', expected '%s'", buf, expected)
+	}
+}
+
+func TestProtectedFileClose(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDEFGH"))
+	md, parityFiles := encodeTmp(t, dataFile, 2, 1)
+	pf, err := NewProtectedFile(dataFile, parityFiles, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pf.Write([]byte("Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pf.Close()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	assertMetadataMatchesEncode(t, md, []byte("ZBCDEFGH"))
+
+	_, err = dataFile.Stat()
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Expected data file to be closed, got %v", err)
+	}
+}
+
+func TestProtectedFileWriteRollsBackFailedWrite(t *testing.T) {
+	original := []byte("0123456789")
+	dataFile := CreateTMPFile(t, original)
+	md, parityFiles := encodeTmp(t, dataFile, 2, 1)
+
+	pf, err := NewProtectedFile(dataFile, reopenParityFile(t, parityFiles, os.O_RDONLY), md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pf.WriteAt([]byte("XX"), 0)
+	if err == nil {
+		t.Fatal("Expected writing to a read-only parity file to fail")
+	}
+	contents, err := ioutil.ReadFile(dataFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, original) {
+		t.Errorf("Expected the failed write to be rolled back, got '%s'", contents)
+	}
+	err = pf.Sync()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	assertMetadataMatchesEncode(t, pf.Metadata(), original)
+}
@@ -5,8 +5,7 @@ import (
 	"fmt"
 	"hash"
 	"io"
-
-	"github.com/klauspost/reedsolomon"
+	"time"
 )
 
 type ShardCreator struct {
@@ -14,34 +13,42 @@ type ShardCreator struct {
 	size         int64
 	dataShards   int
 	parityShards int
+	opts         options
 }
 
-func NewShardCreator(src []io.Reader, size int64, dataShards, parityShards int) *ShardCreator {
+func NewShardCreator(src []io.Reader, size int64, dataShards, parityShards int, opts ...Option) *ShardCreator {
 	return &ShardCreator{
 		dataSources:  src,
 		size:         size,
 		dataShards:   dataShards,
 		parityShards: parityShards,
+		opts:         newOptions(opts),
 	}
 }
 
 func (p *ShardCreator) Encode(parityDst []io.Writer) (*Metadata, error) {
-	RSEncoder, err := reedsolomon.NewStream(p.dataShards, p.parityShards)
+	defer observeLatency(p.opts.metrics, OpShardCreatorEncode, time.Now())
+	if p.opts.localGroupSize > 0 {
+		return p.encodeWithLocalGroups(parityDst)
+	}
+	RSEncoder, err := p.opts.newStreamEncoder(p.dataShards, p.parityShards, p.opts.matrix)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating reedsolomon encoder: %s", err)
 	}
 
 	hashers := make([]hash.Hash, p.dataShards+p.parityShards)
+	hashCounters := make([]*countingWriter, len(hashers))
 	for i := range hashers {
 		hashers[i] = sha256.New()
+		hashCounters[i] = &countingWriter{w: hashers[i]}
 	}
 	hashingReaders := make([]io.Reader, p.dataShards)
 	for i := range hashingReaders {
-		hashingReaders[i] = io.TeeReader(p.dataSources[i], hashers[i])
+		hashingReaders[i] = io.TeeReader(p.dataSources[i], hashCounters[i])
 	}
 	hashingWriters := make([]io.Writer, p.parityShards)
 	for i := range hashingWriters {
-		hashingWriters[i] = io.MultiWriter(parityDst[i], hashers[p.dataShards+i])
+		hashingWriters[i] = io.MultiWriter(parityDst[i], hashCounters[p.dataShards+i])
 	}
 
 	err = RSEncoder.Encode(hashingReaders, hashingWriters)
@@ -50,13 +57,17 @@ func (p *ShardCreator) Encode(parityDst []io.Writer) (*Metadata, error) {
 	}
 
 	hashes := make([]string, len(hashers))
+	var bytesHashed int64
 	for i := range hashers {
 		hashes[i] = fmt.Sprintf("%x", hashers[i].Sum(nil))
+		bytesHashed += hashCounters[i].n
 	}
+	p.opts.metrics.BytesHashed(OpShardCreatorEncode, bytesHashed)
 	return &Metadata{
 		Size:         p.size,
 		Hashes:       hashes,
 		DataShards:   p.dataShards,
 		ParityShards: p.parityShards,
+		Matrix:       p.opts.matrix,
 	}, nil
 }
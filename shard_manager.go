@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/klauspost/reedsolomon"
 )
@@ -11,87 +12,404 @@ import (
 type ShardManager struct {
 	DataSources []io.ReadWriteSeeker
 	Metadata    *Metadata
+	opts        options
+	// DataSources wrapped to time out, if WithReadTimeout is set
+	timeoutShards []*timeoutShard
+	// shards repaired to the FallbackDestination
+	relocated relocatedShards
 }
 
-func NewShardManager(src []io.ReadWriteSeeker, meta *Metadata) *ShardManager {
-	return &ShardManager{
+func NewShardManager(src []io.ReadWriteSeeker, meta *Metadata, opts ...Option) *ShardManager {
+	p := &ShardManager{
 		DataSources: src,
 		Metadata:    meta,
+		opts:        newOptions(opts),
+		relocated:   make(relocatedShards),
 	}
+	if p.opts.readTimeout > 0 {
+		p.timeoutShards = make([]*timeoutShard, len(src))
+		for i := range src {
+			p.timeoutShards[i] = newTimeoutShard(src[i], p.opts.readTimeout)
+		}
+	}
+	return p
+}
+
+// source returns the shard with the given index, which times out if
+// WithReadTimeout is set.
+func (p *ShardManager) source(i int) io.ReadWriteSeeker {
+	if p.timeoutShards != nil {
+		return p.timeoutShards[i]
+	}
+	return p.DataSources[i]
+}
+
+// available reports whether the shard with the given index hasn't timed out.
+func (p *ShardManager) available(i int) bool {
+	return p.timeoutShards == nil || p.timeoutShards[i].available()
 }
 
-func (p *ShardManager) findCorruptShards() ([]int, error) {
-	brokenShards := make([]int, 0)
+// startDeadline gives the shard with the given index the read timeout, if
+// WithReadTimeout is set, to get through the pass over it that is about to
+// start.
+func (p *ShardManager) startDeadline(i int) {
+	if p.timeoutShards != nil {
+		p.timeoutShards[i].startDeadline()
+	}
+}
+
+// lock takes advisory locks on every data source backed by a file: shared
+// ones while reading and verifying, exclusive ones while repairing. With
+// WithReadTimeout, a shard whose lock can't be taken because its storage
+// stopped responding times out and is left unlocked, to be treated like any
+// other shard that timed out.
+func (p *ShardManager) lock(exclusive bool) (*shardLocks, error) {
+	if p.timeoutShards == nil {
+		shards := make([]interface{}, len(p.DataSources))
+		for i := range p.DataSources {
+			shards[i] = p.DataSources[i]
+		}
+		return lockShards(shards, exclusive, p.opts.lockTimeout)
+	}
+
+	locks := &shardLocks{locked: make([]fdFile, 0, len(p.timeoutShards))}
+	for _, shard := range p.timeoutShards {
+		if !shard.available() {
+			continue
+		}
+		locked, err := shard.lock(exclusive, p.opts.lockTimeout)
+		if err == ErrShardTimeout {
+			continue
+		}
+		if err != nil {
+			locks.unlock()
+			return nil, err
+		}
+		if locked {
+			locks.locked = append(locks.locked, shard.shard.(fdFile))
+		}
+	}
+	return locks, nil
+}
+
+// findCorruptShards hashes every shard and reports the hashed bytes and the
+// corrupt shards to Metrics, and the corrupt shards to the Observer, under op.
+// A shard that can't be read is treated as an erasure: it is reported as
+// corrupt with an empty hash and the other shards are still checked.
+func (p *ShardManager) findCorruptShards(op string) ([]*CorruptShard, error) {
+	brokenShards := make([]*CorruptShard, 0)
 	for i := 0; i < len(p.Metadata.Hashes); i++ {
+		p.startDeadline(i)
 		hasher := sha256.New()
-		defer p.DataSources[i].Seek(0, 0)
-		_, err := io.Copy(hasher, p.DataSources[i])
+		n, err := io.Copy(hasher, p.source(i))
+		p.source(i).Seek(0, io.SeekStart)
+		p.opts.metrics.BytesHashed(op, n)
 		if err != nil {
-			return nil, fmt.Errorf("Error hashing shard %d: %s", i, err)
+			brokenShards = append(brokenShards, &CorruptShard{index: i})
+			continue
 		}
 		if newHash := fmt.Sprintf("%x", hasher.Sum(nil)); newHash != p.Metadata.Hashes[i] {
-			brokenShards = append(brokenShards, i)
+			brokenShards = append(brokenShards, &CorruptShard{index: i, hash: newHash})
 		}
 	}
+	if len(brokenShards) > 0 {
+		p.opts.metrics.CorruptShardsFound(op, len(brokenShards))
+		p.opts.observer.OnCorruptionDetected(p.shardEvent(op, brokenShards))
+	}
 	return brokenShards, nil
 }
 
+// Read writes the data held by the data shards to dataDst: exactly
+// Metadata.Size bytes, without the padding of the last shard. It does not
+// verify the shards; call CheckHealth first for that.
 func (p *ShardManager) Read(dataDst io.Writer) error {
-	for _, dataSource := range p.DataSources[:p.Metadata.DataShards] {
-		_, err := io.Copy(dataDst, dataSource)
+	return p.ReadRange(dataDst, 0, p.Metadata.Size)
+}
+
+// ReadRange writes length bytes of data starting at offset to dst, reading only
+// the data shards that hold them. If a data shard can't be read or times out,
+// or Repair could only write it to the FallbackDestination, the rest of the
+// range is rebuilt from the other shards instead, without repairing anything.
+func (p *ShardManager) ReadRange(dst io.Writer, offset, length int64) error {
+	if offset < 0 || length < 0 || offset+length > p.Metadata.Size {
+		return fmt.Errorf("Cannot read %d bytes at offset %d: data size is %d", length, offset, p.Metadata.Size)
+	}
+	locks, err := p.lock(false)
+	if err != nil {
+		return err
+	}
+	defer locks.unlock()
+
+	chunkSize := paddedChunkSize(p.Metadata.Size, p.Metadata.DataShards)
+	for length > 0 {
+		shard := int(offset / chunkSize)
+		shardOffset := offset % chunkSize
+		n := chunkSize - shardOffset
+		if n > length {
+			n = length
+		}
+		if p.relocated[shard] {
+			return p.decodeRange(dst, offset, length, shard)
+		}
+		written := &countingWriter{w: dst}
+		p.startDeadline(shard)
+		reader := &shardReader{r: p.source(shard)}
+		err := p.readShard(written, reader, shardOffset, n)
+		if err != nil && reader.err != nil {
+			return p.decodeRange(dst, offset+written.n, length-written.n, shard)
+		}
 		if err != nil {
-			return fmt.Errorf("Error while reading: %s", err)
+			return fmt.Errorf("Error while reading shard %d: %s", shard, err)
 		}
+		offset += n
+		length -= n
 	}
 	return nil
 }
 
+// readShard copies n bytes of shard starting at offset to dst, then rewinds
+// the shard.
+func (p *ShardManager) readShard(dst io.Writer, shard *shardReader, offset, n int64) error {
+	_, err := shard.seek(offset)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(dst, shard, n)
+	shard.r.Seek(0, io.SeekStart)
+	return err
+}
+
+// decodeRange writes length bytes of data starting at offset to dst, rebuilding
+// them from every shard but the data shard failed and the relocated ones.
+func (p *ShardManager) decodeRange(dst io.Writer, offset, length int64, failed int) error {
+	md := p.Metadata
+	shards := make([]io.Reader, md.DataShards+md.ParityShards)
+	for i := range shards {
+		if i == failed || !p.available(i) || p.relocated[i] {
+			continue
+		}
+		p.startDeadline(i)
+		_, err := p.source(i).Seek(0, io.SeekStart)
+		if err != nil {
+			continue
+		}
+		shards[i] = p.source(i)
+	}
+	defer p.rewind()
+	err := decode(shards, md, &rangeWriter{w: dst, skip: offset, left: length}, p.opts)
+	if err != nil {
+		return fmt.Errorf("Error while reading shard %d: it can't be read and rebuilding it failed: %s", failed, err)
+	}
+	return nil
+}
+
+// shardReader remembers why reading a shard failed, to tell read errors apart
+// from errors writing what was read.
+type shardReader struct {
+	r   io.ReadSeeker
+	err error
+}
+
+func (sr *shardReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if err != nil {
+		sr.err = err
+	}
+	return n, err
+}
+
+func (sr *shardReader) seek(offset int64) (int64, error) {
+	n, err := sr.r.Seek(offset, io.SeekStart)
+	if err != nil {
+		sr.err = err
+	}
+	return n, err
+}
+
+// rangeWriter discards the first skip bytes written to it, passes the next
+// left bytes on to w and discards the rest.
+type rangeWriter struct {
+	w    io.Writer
+	skip int64
+	left int64
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if rw.skip >= int64(len(p)) {
+		rw.skip -= int64(len(p))
+		return n, nil
+	}
+	p = p[rw.skip:]
+	rw.skip = 0
+	if int64(len(p)) > rw.left {
+		p = p[:rw.left]
+	}
+	rw.left -= int64(len(p))
+	_, err := rw.w.Write(p)
+	return n, err
+}
+
 func (p *ShardManager) CheckHealth() error {
-	brokenShardIndexes, err := p.findCorruptShards()
+	defer observeLatency(p.opts.metrics, OpCheckHealth, time.Now())
+	locks, err := p.lock(false)
+	if err != nil {
+		return err
+	}
+	defer locks.unlock()
+	brokenShards, err := p.findCorruptShards(OpCheckHealth)
 	if err != nil {
 		return fmt.Errorf("Error while checking shard integrity: %s", err)
 	}
-	if len(brokenShardIndexes) > 0 {
-		return fmt.Errorf("Corrupted shards: %s", fmt.Sprintf("%v", brokenShardIndexes))
+	if len(brokenShards) > 0 {
+		return fmt.Errorf("Corrupted shards: %s", fmt.Sprintf("%v", shardIndexes(brokenShards)))
 	}
 	return nil
 }
 
 func (p *ShardManager) Repair() error {
-	brokenShardIndexes, err := p.findCorruptShards()
+	defer observeLatency(p.opts.metrics, OpRepair, time.Now())
+	locks, err := p.lock(true)
+	if err != nil {
+		return err
+	}
+	defer locks.unlock()
+	brokenShards, err := p.findCorruptShards(OpRepair)
 	if err != nil {
 		return fmt.Errorf("Error while checking shard integrity: %s", err)
 	}
-	if len(brokenShardIndexes) == 0 {
+	if len(brokenShards) == 0 {
 		return nil
 	}
+	if p.relocated.covers(brokenShards) {
+		// Repairing them again would only write them to the fallback
+		// destination again.
+		return ErrShardRelocated
+	}
+
+	event := p.shardEvent(OpRepair, brokenShards)
+	p.opts.observer.OnRepairStarted(event)
+	if len(p.Metadata.LocalGroups) > 0 {
+		err = p.repairWithLocalGroups(shardIndexes(brokenShards))
+	} else {
+		err = p.repairShards(shardIndexes(brokenShards))
+	}
+	reportRepair(p.opts.metrics, OpRepair, err)
+	reportRepairEvent(p.opts.observer, event, err)
+	return err
+}
+
+func (p *ShardManager) shardEvent(op string, brokenShards []*CorruptShard) ShardEvent {
+	return newShardEvent(op, brokenShards, p.Metadata, paddedChunkSize(p.Metadata.Size, p.Metadata.DataShards))
+}
 
+func (p *ShardManager) repairShards(brokenShardIndexes []int) error {
 	if bsCount := len(brokenShardIndexes); bsCount > p.Metadata.ParityShards {
 		return fmt.Errorf("Cannot repair data: %d shards corrupt, only have %d parity shards", bsCount, p.Metadata.ParityShards)
 	}
 
+	RSEncoder, err := p.opts.newDecoder(p.Metadata)
+	if err != nil {
+		return fmt.Errorf("Error creating reedsolomon encoder: %s", err)
+	}
+
+	// Nothing is committed until every reconstructed shard matches its
+	// hash. If they don't, one of the shards they were reconstructed from
+	// went bad after it was verified, so try leaving them out one at a time
+	// while there is parity to spare.
+	survivors := survivingShards(p.Metadata, brokenShardIndexes)
+	spare := p.Metadata.ParityShards - len(brokenShardIndexes)
+	for _, excluded := range survivorExclusions(survivors, spare) {
+		repaired, err := p.reconstructShards(RSEncoder, brokenShardIndexes, excluded)
+		if err == errReconstructionMismatch {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		defer repaired.remove()
+		var relocatedErr error
+		for _, shardIndex := range brokenShardIndexes {
+			err = p.commitShard(shardIndex, repaired[shardIndex])
+			if err == ErrShardRelocated {
+				relocatedErr = err
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return relocatedErr
+	}
+	return fmt.Errorf("Cannot repair data: %s", errReconstructionMismatch)
+}
+
+// reconstructShards reconstructs the broken shards into temporary files from
+// every other shard but the excluded ones, and checks them against their
+// hashes.
+func (p *ShardManager) reconstructShards(encoder reedsolomon.StreamEncoder, broken, excluded []int) (repairFiles, error) {
+	err := p.rewind()
+	if err != nil {
+		return nil, err
+	}
 	shardCount := p.Metadata.DataShards + p.Metadata.ParityShards
 	shardReaders := make([]io.Reader, shardCount)
 	shardWriters := make([]io.Writer, shardCount)
-
-	for i := range p.DataSources {
-		shardReaders[i] = p.DataSources[i].(io.Reader)
+	for i := range shardReaders {
+		shardReaders[i] = p.source(i)
+	}
+	for _, shardIndex := range excluded {
+		shardReaders[shardIndex] = nil
 	}
 
-	// mark shards as broken, mark which shards to write
-	for _, shardIndex := range brokenShardIndexes {
+	repaired, err := newRepairFiles(broken)
+	if err != nil {
+		return nil, err
+	}
+	// mark shards as broken, reconstruct them into temporary files
+	hashWriters := make(map[int]*hashingWriter)
+	for _, shardIndex := range broken {
 		shardReaders[shardIndex] = nil
-		shardWriters[shardIndex] = p.DataSources[shardIndex].(io.Writer)
+		hashWriters[shardIndex] = newHashingWriter(repaired[shardIndex])
+		shardWriters[shardIndex] = hashWriters[shardIndex]
 	}
 
-	RSEncoder, err := reedsolomon.NewStream(p.Metadata.DataShards, p.Metadata.ParityShards)
+	err = encoder.Reconstruct(shardReaders, shardWriters)
 	if err != nil {
-		return fmt.Errorf("Error creating reedsolomon encoder: %s", err)
+		repaired.remove()
+		return nil, fmt.Errorf("Error reconstructing data: %s", err)
+	}
+	for shardIndex, hashWriter := range hashWriters {
+		if hashWriter.Hash() != p.Metadata.Hashes[shardIndex] {
+			repaired.remove()
+			return nil, errReconstructionMismatch
+		}
 	}
+	return repaired, nil
+}
 
-	err = RSEncoder.Reconstruct(shardReaders, shardWriters)
+// commitShard overwrites the shard with the given index with the repaired
+// contents in src, falling back to the FallbackDestination if the shard can't
+// be written, in which case it returns ErrShardRelocated.
+func (p *ShardManager) commitShard(index int, src io.ReadSeeker) error {
+	_, err := src.Seek(0, io.SeekStart)
 	if err != nil {
-		return fmt.Errorf("Error reconstructing data: %s", err)
+		return fmt.Errorf("Error rewinding repair file: %s", err)
 	}
-	return nil
+	return p.opts.commitShard(index, src, p.relocated, func(src io.Reader) error {
+		p.startDeadline(index)
+		dst := p.source(index)
+		_, err := dst.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		if err != nil {
+			return err
+		}
+		if syncer, ok := dst.(interface{ Sync() error }); ok {
+			return syncer.Sync()
+		}
+		return nil
+	})
 }
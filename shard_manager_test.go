@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"reflect"
+	"syscall"
 	"testing"
 )
 
@@ -249,3 +251,288 @@ func TestE2EUnevenInput(t *testing.T) {
 		t.Errorf("Got health error %s, expected nil", err)
 	}
 }
+
+func TestShardManagerRepairPaddedChunkKeepsSize(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataFile := CreateTMPFile(t, original)
+	md, parityFiles := encodeTmp(t, dataFile, 2, 1)
+	_, err = dataFile.WriteAt([]byte{0xff}, md.Size-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parityFiles[0].Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := SplitIntoPaddedChunks(dataFile, md.Size, md.DataShards)
+	shards := []io.ReadWriteSeeker{chunks[0], chunks[1], parityFiles[0]}
+	err = NewShardManager(shards, md).Repair()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	contents, err := ioutil.ReadFile(dataFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, original) {
+		t.Errorf("Got %d bytes:\n%s\nexpected %d bytes:\n%s", len(contents), contents, len(original), original)
+	}
+}
+
+// unevenShards encodes testdata/uneven_input1 into 3 data shards and 1 parity
+// shard, each stored padded in its own file.
+func unevenShards(t *testing.T) ([]byte, []io.ReadWriteSeeker, *Metadata) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataFile := CreateTMPFile(t, original)
+	md, parityFiles := encodeTmp(t, dataFile, 3, 1)
+
+	shards := make([]io.ReadWriteSeeker, 0, 4)
+	for _, chunk := range SplitIntoPaddedChunks(dataFile, md.Size, md.DataShards) {
+		contents, err := ioutil.ReadAll(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards = append(shards, CreateTMPFile(t, contents))
+	}
+	shards = append(shards, parityFiles[0])
+	for _, shard := range shards {
+		_, err = shard.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return original, shards, md
+}
+
+func TestShardManagerReadUnevenInput(t *testing.T) {
+	original, shards, md := unevenShards(t)
+	// Leave a data source mid-stream.
+	_, err := shards[1].Read(make([]byte, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var readBuf bytes.Buffer
+	err = NewShardManager(shards, md).Read(&readBuf)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(readBuf.Bytes(), original) {
+		t.Errorf("Got %d bytes:\n%s\nexpected %d bytes:\n%s", readBuf.Len(), readBuf.Bytes(), len(original), original)
+	}
+}
+
+func TestShardManagerReadRange(t *testing.T) {
+	original, shards, md := unevenShards(t)
+	chunkSize := paddedChunkSize(md.Size, md.DataShards)
+	tests := []struct {
+		name   string
+		offset int64
+		length int64
+	}{
+		{"start", 0, 10},
+		{"within a shard", 20, 30},
+		{"across shards", chunkSize - 5, 10},
+		{"across all shards", 1, md.Size - 2},
+		{"end", md.Size - 7, 7},
+		{"empty", 100, 0},
+	}
+	manager := NewShardManager(shards, md)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var readBuf bytes.Buffer
+			err := manager.ReadRange(&readBuf, tt.offset, tt.length)
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			expected := original[tt.offset : tt.offset+tt.length]
+			if !bytes.Equal(readBuf.Bytes(), expected) {
+				t.Errorf("Got '%s', expected '%s'", readBuf.Bytes(), expected)
+			}
+		})
+	}
+}
+
+func TestShardManagerReadRangeOutOfBounds(t *testing.T) {
+	_, shards, md := unevenShards(t)
+	manager := NewShardManager(shards, md)
+
+	expectedErrMsg := fmt.Sprintf("Cannot read 10 bytes at offset %d: data size is %d", md.Size-5, md.Size)
+	err := manager.ReadRange(ioutil.Discard, md.Size-5, 10)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
+
+// failingShard fails every Read with readErr and every Write with writeErr,
+// when they are set, like a shard on a failing disk.
+type failingShard struct {
+	io.ReadWriteSeeker
+	readErr  error
+	writeErr error
+}
+
+func (s *failingShard) Read(p []byte) (int, error) {
+	if s.readErr != nil {
+		return 0, s.readErr
+	}
+	return s.ReadWriteSeeker.Read(p)
+}
+
+func (s *failingShard) Write(p []byte) (int, error) {
+	if s.writeErr != nil {
+		return 0, s.writeErr
+	}
+	return s.ReadWriteSeeker.Write(p)
+}
+
+func TestShardManagerRepairUnreadableShard(t *testing.T) {
+	md := getMetadata()
+	shards := getShards(t)
+	unreadable := &failingShard{ReadWriteSeeker: shards[0], readErr: syscall.EIO}
+	shards[0] = unreadable
+
+	expectedErrMsg := "Corrupted shards: [0]"
+	err := NewShardManager(shards, md).CheckHealth()
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+	err = NewShardManager(shards, md).Repair()
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	unreadable.readErr = nil
+	for _, shard := range shards {
+		_, err = shard.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = NewShardManager(shards, md).CheckHealth()
+	if err != nil {
+		t.Errorf("Expected nil error after repair, got %s", err)
+	}
+}
+
+func TestShardManagerRepairUnwritableShard(t *testing.T) {
+	md := getMetadata()
+	shards := getShards(t)
+	err := corruptShard(shards[1], int(md.Size)/md.DataShards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards[1] = &failingShard{ReadWriteSeeker: shards[1], writeErr: syscall.EIO}
+
+	expectedErrMsg := "Error writing repaired shard 1: input/output error"
+	err = NewShardManager(shards, md).Repair()
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+	for _, shard := range shards {
+		_, err = shard.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var fallback bytes.Buffer
+	fallbackShard := -1
+	fallbackCalls := 0
+	observer := &recordingObserver{}
+	manager := NewShardManager(shards, md, WithObserver(observer), WithFallbackDestination(func(shard int) (io.Writer, error) {
+		fallbackShard = shard
+		fallbackCalls++
+		return &fallback, nil
+	}))
+	err = manager.Repair()
+	if err != ErrShardRelocated {
+		t.Fatalf("Expected ErrShardRelocated, got %v", err)
+	}
+	if fallbackShard != 1 {
+		t.Errorf("Got shard %d written to the fallback destination, expected 1", fallbackShard)
+	}
+	if hash := fmt.Sprintf("%x", sha256.Sum256(fallback.Bytes())); hash != md.Hashes[1] {
+		t.Errorf("Got hash %s for the repaired shard, expected %s", hash, md.Hashes[1])
+	}
+	expectedCalls := []string{"detected", "started", "failed"}
+	if !reflect.DeepEqual(observer.calls, expectedCalls) {
+		t.Fatalf("Got calls %v, expected %v", observer.calls, expectedCalls)
+	}
+	if observer.events[2].Err != ErrShardRelocated {
+		t.Errorf("Expected the failed repair to report ErrShardRelocated, got %v", observer.events[2].Err)
+	}
+
+	// The shard is still corrupt, but it isn't written to the fallback
+	// destination again.
+	for _, shard := range shards {
+		_, err = shard.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = manager.Repair()
+	if err != ErrShardRelocated {
+		t.Errorf("Expected ErrShardRelocated from the second Repair, got %v", err)
+	}
+	if fallbackCalls != 1 {
+		t.Errorf("Expected the fallback destination to be written once, got %d times", fallbackCalls)
+	}
+}
+
+func TestShardManagerReadRangeRelocatedShard(t *testing.T) {
+	original, shards, md := unevenShards(t)
+	chunkSize := paddedChunkSize(md.Size, md.DataShards)
+	err := corruptShard(shards[1], int(chunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards[1] = &failingShard{ReadWriteSeeker: shards[1], writeErr: syscall.EIO}
+
+	manager := NewShardManager(shards, md, WithFallbackDestination(func(shard int) (io.Writer, error) {
+		return ioutil.Discard, nil
+	}))
+	err = manager.Repair()
+	if err != ErrShardRelocated {
+		t.Fatalf("Expected ErrShardRelocated, got %v", err)
+	}
+	for _, shard := range shards {
+		_, err = shard.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var readBuf bytes.Buffer
+	err = manager.Read(&readBuf)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(readBuf.Bytes(), original) {
+		t.Errorf("Got %d bytes:\n%s\nexpected %d bytes:\n%s", readBuf.Len(), readBuf.Bytes(), len(original), original)
+	}
+}
+
+func TestShardManagerReadRangeUnreadableShard(t *testing.T) {
+	original, shards, md := unevenShards(t)
+	chunkSize := paddedChunkSize(md.Size, md.DataShards)
+	shards[1] = &failingShard{ReadWriteSeeker: shards[1], readErr: syscall.EIO}
+
+	var readBuf bytes.Buffer
+	offset, length := chunkSize-10, chunkSize+5
+	err := NewShardManager(shards, md).ReadRange(&readBuf, offset, length)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if expected := original[offset : offset+length]; !bytes.Equal(readBuf.Bytes(), expected) {
+		t.Errorf("Got %d bytes:\n%s\nexpected %d bytes:\n%s", readBuf.Len(), readBuf.Bytes(), len(expected), expected)
+	}
+}
@@ -0,0 +1,115 @@
+package rsutils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Split divides f into len(dataWriters) data shards and len(parityWriters)
+// parity shards and writes every shard to its own writer, so the shards can be
+// stored apart from each other and from f. Unlike Encode, which leaves the
+// data in f, any len(dataWriters) of the shards are enough to rebuild f with
+// Join. The last data shard is padded with zeros; Join strips the padding using
+// Metadata.Size.
+func Split(f *os.File, dataWriters, parityWriters []io.Writer, opts ...Option) (*Metadata, error) {
+	o := newOptions(opts)
+	defer observeLatency(o.metrics, OpSplit, time.Now())
+	if len(dataWriters) == 0 {
+		return nil, fmt.Errorf("Cannot split: need at least one data writer")
+	}
+	return encode(OpSplit, f, len(dataWriters), dataWriters, parityWriters, o)
+}
+
+// Join rebuilds the data divided by Split and writes exactly md.Size bytes of
+// it to dst. shards holds the data shards followed by the parity shards, with
+// nil for missing ones. Every shard is verified against its hash first and
+// treated as missing if it is corrupt, so any md.DataShards intact shards are
+// enough.
+func Join(shards []io.ReadSeeker, md *Metadata, dst io.Writer, opts ...Option) error {
+	o := newOptions(opts)
+	defer observeLatency(o.metrics, OpJoin, time.Now())
+	if len(shards) != md.DataShards+md.ParityShards {
+		return fmt.Errorf("Cannot join shards: need %d shards, got %d", md.DataShards+md.ParityShards, len(shards))
+	}
+
+	valid, err := verifyShards(OpJoin, shards, md, o)
+	if err != nil {
+		return err
+	}
+	encoder, err := o.newDecoder(md)
+	if err != nil {
+		return fmt.Errorf("Cannot join shards: %s", err)
+	}
+
+	dataReaders := make([]io.Reader, md.DataShards)
+	fill := make([]io.Writer, len(shards))
+	missing := false
+	for i := range dataReaders {
+		if valid[i] != nil {
+			dataReaders[i] = valid[i]
+			continue
+		}
+		tempFile, err := ioutil.TempFile("", "rsutils_join")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tempFile.Name())
+		defer tempFile.Close()
+		dataReaders[i] = tempFile
+		fill[i] = tempFile
+		missing = true
+	}
+	if missing {
+		err = encoder.Reconstruct(valid, fill)
+		if err != nil {
+			return fmt.Errorf("Error reconstructing data: %s", err)
+		}
+		for i, dataReader := range dataReaders {
+			_, err = dataReader.(io.Seeker).Seek(0, io.SeekStart)
+			if err != nil {
+				return fmt.Errorf("Error rewinding shard %d: %s", i, err)
+			}
+		}
+	}
+
+	err = encoder.Join(dst, dataReaders, md.Size)
+	if err != nil {
+		return fmt.Errorf("Error joining shards: %s", err)
+	}
+	return nil
+}
+
+// verifyShards hashes every shard that isn't nil and returns the intact ones,
+// rewound, with nil in place of the missing and corrupt ones. It fails if
+// fewer than md.DataShards shards are intact.
+func verifyShards(op string, shards []io.ReadSeeker, md *Metadata, o options) ([]io.Reader, error) {
+	valid := make([]io.Reader, len(shards))
+	intact := 0
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		hasher := sha256.New()
+		n, err := io.Copy(hasher, shard)
+		o.metrics.BytesHashed(op, n)
+		if err != nil {
+			return nil, fmt.Errorf("Error hashing shard %d: %s", i, err)
+		}
+		_, err = shard.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, fmt.Errorf("Error rewinding shard %d: %s", i, err)
+		}
+		if fmt.Sprintf("%x", hasher.Sum(nil)) == md.Hashes[i] {
+			valid[i] = shard
+			intact++
+		}
+	}
+	if intact < md.DataShards {
+		return nil, fmt.Errorf("Cannot join shards: only %d shards intact, need %d", intact, md.DataShards)
+	}
+	return valid, nil
+}
@@ -0,0 +1,120 @@
+package rsutils
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// splitBytes splits contents into dataShards data shards and parityShards
+// parity shards held in memory.
+func splitBytes(t *testing.T, contents []byte, dataShards, parityShards int) ([][]byte, *Metadata) {
+	buffers := make([]*bytes.Buffer, dataShards+parityShards)
+	writers := make([]io.Writer, len(buffers))
+	for i := range buffers {
+		buffers[i] = &bytes.Buffer{}
+		writers[i] = buffers[i]
+	}
+	md, err := Split(CreateTMPFile(t, contents), writers[:dataShards], writers[dataShards:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := make([][]byte, len(buffers))
+	for i := range buffers {
+		shards[i] = buffers[i].Bytes()
+	}
+	return shards, md
+}
+
+// splitTestFile splits testdata/uneven_input1 into dataShards data shards and
+// parityShards parity shards held in memory.
+func splitTestFile(t *testing.T, dataShards, parityShards int) ([]byte, [][]byte, *Metadata) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards, md := splitBytes(t, original, dataShards, parityShards)
+	return original, shards, md
+}
+
+func TestSplit(t *testing.T) {
+	original, shards, md := splitTestFile(t, 3, 2)
+
+	chunkSize := int(paddedChunkSize(md.Size, md.DataShards))
+	for i, shard := range shards {
+		if len(shard) != chunkSize {
+			t.Errorf("Got %d bytes in shard %d, expected %d", len(shard), i, chunkSize)
+		}
+	}
+	joined := bytes.Join(shards[:3], nil)
+	if !bytes.Equal(joined[:len(original)], original) {
+		t.Errorf("Expected the data shards to hold the original data")
+	}
+
+	encodedMd, _ := encodeTmp(t, CreateTMPFile(t, original), 3, 2)
+	if len(md.Hashes) != len(encodedMd.Hashes) || md.Size != encodedMd.Size {
+		t.Fatalf("Got metadata %+v, expected %+v", md, encodedMd)
+	}
+	for i := range md.Hashes {
+		if md.Hashes[i] != encodedMd.Hashes[i] {
+			t.Errorf("Got hash %s for shard %d, expected the same as Encode: %s", md.Hashes[i], i, encodedMd.Hashes[i])
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		name          string
+		missingShards []int
+		corruptShards []int
+		expectedErr   string
+	}{
+		{"all shards", nil, nil, ""},
+		{"missing data shards", []int{0, 2}, nil, ""},
+		{"missing parity shards", []int{3, 4}, nil, ""},
+		{"corrupt data shard", []int{1}, []int{2}, ""},
+		{"too few shards", []int{0, 1}, []int{4}, "Cannot join shards: only 2 shards intact, need 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original, shardContents, md := splitTestFile(t, 3, 2)
+			shards := make([]io.ReadSeeker, len(shardContents))
+			for i := range shardContents {
+				shards[i] = bytes.NewReader(shardContents[i])
+			}
+			for _, i := range tt.missingShards {
+				shards[i] = nil
+			}
+			for _, i := range tt.corruptShards {
+				shardContents[i][0] ^= 0xff
+			}
+
+			var joined bytes.Buffer
+			err := Join(shards, md, &joined)
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Errorf("Expected error '%s', got '%v'", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			if !bytes.Equal(joined.Bytes(), original) {
+				t.Errorf("Got %d bytes:\n%s\nexpected %d bytes:\n%s", joined.Len(), joined.Bytes(), len(original), original)
+			}
+		})
+	}
+}
+
+func TestJoinWrongShardCount(t *testing.T) {
+	_, _, md := splitTestFile(t, 3, 2)
+
+	expectedErrMsg := "Cannot join shards: need 5 shards, got 3"
+	err := Join(make([]io.ReadSeeker, 3), md, ioutil.Discard)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
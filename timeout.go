@@ -0,0 +1,158 @@
+package rsutils
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrShardTimeout is returned for a shard that didn't respond within the
+// timeout set by WithReadTimeout. The shard is treated as unavailable from
+// then on.
+var ErrShardTimeout = errors.New("Timed out waiting for shard")
+
+// timeoutShard gives up on a shard that takes longer than timeout to get
+// through a pass over it: startDeadline is called before each pass, and Read,
+// Write and Seek calls fail once the deadline passes, so a shard that trickles
+// data times out as surely as one that hangs. The call that timed out is left
+// running in the background, so every later call fails straight away with
+// ErrShardTimeout rather than touching the shard again. Reads and writes go
+// through buffers owned by timeoutShard, so an abandoned call never touches the
+// caller's.
+type timeoutShard struct {
+	shard    io.ReadWriteSeeker
+	timeout  time.Duration
+	deadline time.Time
+	buf      []byte
+	// set once a call timed out
+	err error
+}
+
+func newTimeoutShard(shard io.ReadWriteSeeker, timeout time.Duration) *timeoutShard {
+	s := &timeoutShard{shard: shard, timeout: timeout}
+	s.startDeadline()
+	return s
+}
+
+// startDeadline gives the calls made from now on timeout to complete.
+func (s *timeoutShard) startDeadline() {
+	s.deadline = time.Now().Add(s.timeout)
+}
+
+type callResult struct {
+	n   int64
+	err error
+}
+
+// call runs fn in the background and waits for it until the deadline.
+func (s *timeoutShard) call(fn func() (int64, error)) (int64, error) {
+	return s.callUntil(s.deadline, fn)
+}
+
+// callUntil runs fn in the background and waits for it until deadline.
+func (s *timeoutShard) callUntil(deadline time.Time, fn func() (int64, error)) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	done := make(chan callResult, 1)
+	go func() {
+		n, err := fn()
+		done <- callResult{n, err}
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		return result.n, result.err
+	case <-timer.C:
+		s.err = ErrShardTimeout
+		// The abandoned call still owns the buffer.
+		s.buf = nil
+		return 0, s.err
+	}
+}
+
+// lock takes an advisory lock on the shard if it is backed by a file
+// descriptor, waiting at most lockTimeout for others to release theirs, or
+// indefinitely if it is 0. Each attempt to take the lock gets timeout to
+// return, so a shard on a hung mount times out like it would on a read, while
+// a lock that is merely held by somebody else is still waited for. It reports
+// whether the shard was locked.
+func (s *timeoutShard) lock(exclusive bool, lockTimeout time.Duration) (bool, error) {
+	f, ok := s.shard.(fdFile)
+	if !ok {
+		return false, nil
+	}
+	lockDeadline := time.Now().Add(lockTimeout)
+	for {
+		locked, err := s.callUntil(time.Now().Add(s.timeout), func() (int64, error) {
+			locked, err := tryLockFile(f.Fd(), exclusive)
+			if locked {
+				return 1, err
+			}
+			return 0, err
+		})
+		if err != nil || locked == 1 {
+			return locked == 1, err
+		}
+		remaining := time.Until(lockDeadline)
+		if lockTimeout > 0 && remaining <= 0 {
+			return false, ErrLockTimeout
+		}
+		if lockTimeout <= 0 || remaining > lockPollInterval {
+			remaining = lockPollInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// buffer returns a buffer of n bytes the shard can be read into or written from.
+func (s *timeoutShard) buffer(n int) []byte {
+	if len(s.buf) < n {
+		s.buf = make([]byte, n)
+	}
+	return s.buf[:n]
+}
+
+func (s *timeoutShard) Read(p []byte) (int, error) {
+	buf := s.buffer(len(p))
+	n, err := s.call(func() (int64, error) {
+		n, err := s.shard.Read(buf)
+		return int64(n), err
+	})
+	copy(p, buf[:n])
+	return int(n), err
+}
+
+func (s *timeoutShard) Write(p []byte) (int, error) {
+	buf := s.buffer(len(p))
+	copy(buf, p)
+	n, err := s.call(func() (int64, error) {
+		n, err := s.shard.Write(buf)
+		return int64(n), err
+	})
+	return int(n), err
+}
+
+func (s *timeoutShard) Seek(offset int64, whence int) (int64, error) {
+	return s.call(func() (int64, error) {
+		return s.shard.Seek(offset, whence)
+	})
+}
+
+// Sync syncs the shard if it supports it.
+func (s *timeoutShard) Sync() error {
+	syncer, ok := s.shard.(interface{ Sync() error })
+	if !ok {
+		return nil
+	}
+	_, err := s.call(func() (int64, error) {
+		return 0, syncer.Sync()
+	})
+	return err
+}
+
+// available reports whether the shard hasn't timed out yet.
+func (s *timeoutShard) available() bool {
+	return s.err == nil
+}
@@ -0,0 +1,160 @@
+package rsutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// testShardTimeout is long enough for reading a healthy shard, even when the
+// tests are run with -race.
+const testShardTimeout = 250 * time.Millisecond
+
+// hangingShard blocks every Read until the test ends, like a shard on a hung
+// network mount.
+type hangingShard struct {
+	io.ReadWriteSeeker
+	release chan struct{}
+}
+
+func newHangingShard(t *testing.T, shard io.ReadWriteSeeker) *hangingShard {
+	s := &hangingShard{ReadWriteSeeker: shard, release: make(chan struct{})}
+	t.Cleanup(func() { close(s.release) })
+	return s
+}
+
+func (s *hangingShard) Read(p []byte) (int, error) {
+	<-s.release
+	return 0, io.ErrUnexpectedEOF
+}
+
+// tricklingShard returns one byte per Read, slowly enough that reading the
+// whole shard takes longer than testShardTimeout while no single Read does.
+type tricklingShard struct {
+	io.ReadWriteSeeker
+}
+
+func (s *tricklingShard) Read(p []byte) (int, error) {
+	time.Sleep(testShardTimeout / 10)
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return s.ReadWriteSeeker.Read(p)
+}
+
+// stalledLockShard is a shard on a hung network mount that hasn't been read
+// yet: taking a lock on it never returns.
+type stalledLockShard struct {
+	*os.File
+	release chan struct{}
+}
+
+func newStalledLockShard(t *testing.T, shard *os.File) *stalledLockShard {
+	s := &stalledLockShard{File: shard, release: make(chan struct{})}
+	t.Cleanup(func() { close(s.release) })
+	return s
+}
+
+func (s *stalledLockShard) Fd() uintptr {
+	<-s.release
+	return s.File.Fd()
+}
+
+func TestTimeoutShard(t *testing.T) {
+	shard := newTimeoutShard(newHangingShard(t, CreateTMPFile(t, []byte("ABCD"))), testShardTimeout)
+
+	_, err := shard.Seek(2, io.SeekStart)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	_, err = shard.Read(make([]byte, 2))
+	if err != ErrShardTimeout {
+		t.Errorf("Got error %v, expected ErrShardTimeout", err)
+	}
+	_, err = shard.Seek(0, io.SeekStart)
+	if err != ErrShardTimeout {
+		t.Errorf("Got error %v after timing out, expected ErrShardTimeout", err)
+	}
+	if shard.available() {
+		t.Errorf("Expected the shard to be unavailable after timing out")
+	}
+}
+
+func TestShardManagerCheckHealthTimeout(t *testing.T) {
+	_, shards, md := unevenShards(t)
+	shards[1] = newHangingShard(t, shards[1])
+
+	expectedErrMsg := "Corrupted shards: [1]"
+	err := NewShardManager(shards, md, WithReadTimeout(testShardTimeout)).CheckHealth()
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
+
+func TestShardManagerCheckHealthTimesOutTricklingShard(t *testing.T) {
+	_, shards, md := unevenShards(t)
+	shards[1] = &tricklingShard{shards[1]}
+
+	expectedErrMsg := "Corrupted shards: [1]"
+	err := NewShardManager(shards, md, WithReadTimeout(testShardTimeout)).CheckHealth()
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
+
+func TestShardManagerCheckHealthTimesOutStalledLock(t *testing.T) {
+	_, shards, md := unevenShards(t)
+	shards[1] = newStalledLockShard(t, shards[1].(*os.File))
+
+	expectedErrMsg := "Corrupted shards: [1]"
+	err := NewShardManager(shards, md, WithReadTimeout(testShardTimeout)).CheckHealth()
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+}
+
+func TestShardManagerReadTimeout(t *testing.T) {
+	original, shards, md := unevenShards(t)
+	shards[1] = newHangingShard(t, shards[1])
+
+	var readBuf bytes.Buffer
+	err := NewShardManager(shards, md, WithReadTimeout(testShardTimeout)).Read(&readBuf)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if !bytes.Equal(readBuf.Bytes(), original) {
+		t.Errorf("Got %d bytes:\n%s\nexpected %d bytes:\n%s", readBuf.Len(), readBuf.Bytes(), len(original), original)
+	}
+}
+
+func TestShardManagerRepairTimeout(t *testing.T) {
+	_, shards, md := unevenShards(t)
+	shards[1] = newHangingShard(t, shards[1])
+
+	expectedErrMsg := "Error writing repaired shard 1: Timed out waiting for shard"
+	err := NewShardManager(shards, md, WithReadTimeout(testShardTimeout)).Repair()
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%v'", expectedErrMsg, err)
+	}
+	for _, shard := range shards {
+		_, err = shard.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var fallback bytes.Buffer
+	err = NewShardManager(shards, md, WithReadTimeout(testShardTimeout), WithFallbackDestination(func(shard int) (io.Writer, error) {
+		return &fallback, nil
+	})).Repair()
+	if err != ErrShardRelocated {
+		t.Fatalf("Expected ErrShardRelocated, got %v", err)
+	}
+	if hash := fmt.Sprintf("%x", sha256.Sum256(fallback.Bytes())); hash != md.Hashes[1] {
+		t.Errorf("Got hash %s for the repaired shard, expected %s", hash, md.Hashes[1])
+	}
+}
@@ -0,0 +1,295 @@
+package rsutils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Updater applies in-place writes to a Reed-Solomon-encoded data file. Because
+// Reed-Solomon is linear, parity is updated from the difference between the old
+// and the new bytes, so only the affected region of each parity shard is
+// rewritten instead of re-encoding the whole file.
+type Updater struct {
+	data        *os.File
+	parityFiles []*os.File
+	md          *Metadata
+	chunkSize   int64
+	encoder     reedsolomon.Encoder
+}
+
+// NewUpdater accepts a data file opened for writing, its parity files and the
+// Metadata returned by Encode. The Metadata is updated in place on every write.
+// If a previous write through an Updater was interrupted, NewUpdater rolls it
+// back first.
+func NewUpdater(data *os.File, parityFiles []*os.File, md *Metadata) (*Updater, error) {
+	if len(parityFiles) != md.ParityShards {
+		return nil, fmt.Errorf("Cannot open encoded files: need %d parity shards, got %d", md.ParityShards, len(parityFiles))
+	}
+	// Writes would leave the local parity shards stale.
+	if len(md.LocalGroups) > 0 {
+		return nil, fmt.Errorf("Cannot open encoded files: local groups are only supported by ShardManager")
+	}
+	encoder, err := newBlockEncoder(md)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating reedsolomon encoder: %s", err)
+	}
+	u := &Updater{
+		data:        data,
+		parityFiles: parityFiles,
+		md:          md,
+		chunkSize:   paddedChunkSize(md.Size, md.DataShards),
+		encoder:     encoder,
+	}
+	locks, err := u.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer locks.unlock()
+	err = recoverUpdateJournal(data, parityFiles, md)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot recover interrupted write: %s", err)
+	}
+	return u, nil
+}
+
+// WriteAt writes p to the data file at offset off, updates the parity shards
+// and refreshes the hashes of every shard that changed.
+// Writes cannot extend the data file past Metadata.Size; changing the size
+// changes how the file is split into shards and requires running Encode again.
+// A write is applied completely or not at all: the bytes it overwrites are
+// journaled first, and restored if it fails, or by the next Open or NewUpdater
+// if the process crashes in the middle of it. The Metadata only changes once
+// the write is on stable storage. The data and parity files are locked
+// exclusively for the duration of the write, so FileDecoders in other
+// processes neither read nor repair the shards while they are half-updated.
+func (u *Updater) WriteAt(p []byte, off int64) (int, error) {
+	var hashes map[int]string
+	_, err := u.journaledWrite(p, off, func(changedShards []int) error {
+		var err error
+		hashes, err = u.hashShards(changedShards)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, shardHash := range hashes {
+		u.md.Hashes[i] = shardHash
+	}
+	return len(p), nil
+}
+
+// journaledWrite writes p to the data file at offset off and updates the parity
+// shards under exclusive locks, journaling the bytes it overwrites first. Once
+// the write is on stable storage, beforeCommit, if not nil, is called with the
+// data shards that were written to before the journal is removed. If anything
+// fails, the write is rolled back. It returns the data shards written to.
+func (u *Updater) journaledWrite(p []byte, off int64, beforeCommit func(changedShards []int) error) ([]int, error) {
+	if off < 0 || off+int64(len(p)) > u.md.Size {
+		return nil, fmt.Errorf("Cannot write %d bytes at offset %d: data size is %d", len(p), off, u.md.Size)
+	}
+	locks, err := u.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer locks.unlock()
+	journal, err := u.journalWrite(p, off)
+	if err != nil {
+		return nil, err
+	}
+
+	changedShards, err := u.updateParity(p, off)
+	if err == nil {
+		err = syncFiles(u.data, u.parityFiles)
+	}
+	if err == nil && beforeCommit != nil {
+		err = beforeCommit(changedShards)
+	}
+	if err == nil {
+		err = os.Remove(updateJournalPath(u.data))
+	}
+	if err != nil {
+		rollbackErr := rollbackUpdate(u.data, u.parityFiles, u.md, journal)
+		if rollbackErr != nil {
+			return nil, fmt.Errorf("%s; rolling the write back failed too: %s", err, rollbackErr)
+		}
+		return nil, err
+	}
+	return changedShards, nil
+}
+
+// lock takes exclusive advisory locks on the data and parity files.
+func (u *Updater) lock() (*shardLocks, error) {
+	files := []interface{}{u.data}
+	for _, parityFile := range u.parityFiles {
+		files = append(files, parityFile)
+	}
+	return lockShards(files, true, 0)
+}
+
+// journalWrite records the bytes of the data and parity shards that writing p
+// at offset off overwrites in the update journal.
+func (u *Updater) journalWrite(p []byte, off int64) (*updateJournal, error) {
+	journal := &updateJournal{}
+	// Parity is updated in the same columns as the data shards, so the
+	// region to save in every parity shard spans all of them.
+	parityStart, parityEnd := u.chunkSize, int64(0)
+	for written := int64(0); written < int64(len(p)); {
+		pos := off + written
+		shardIdx := int(pos / u.chunkSize)
+		shardOffset := pos % u.chunkSize
+		segmentLen := u.chunkSize - shardOffset
+		if remaining := int64(len(p)) - written; segmentLen > remaining {
+			segmentLen = remaining
+		}
+		old := make([]byte, segmentLen)
+		_, err := u.data.ReadAt(old, pos)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading data shard %d: %s", shardIdx, err)
+		}
+		journal.Regions = append(journal.Regions, journalRegion{Shard: shardIdx, Offset: shardOffset, Old: old})
+		if shardOffset < parityStart {
+			parityStart = shardOffset
+		}
+		if shardOffset+segmentLen > parityEnd {
+			parityEnd = shardOffset + segmentLen
+		}
+		written += segmentLen
+	}
+	for i, parityFile := range u.parityFiles {
+		if parityStart >= parityEnd {
+			break
+		}
+		old := make([]byte, parityEnd-parityStart)
+		_, err := parityFile.ReadAt(old, parityStart)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading parity shard %d: %s", u.md.DataShards+i, err)
+		}
+		journal.Regions = append(journal.Regions, journalRegion{Shard: u.md.DataShards + i, Offset: parityStart, Old: old})
+	}
+
+	err := writeJournal(updateJournalPath(u.data), journal)
+	if err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// updateParity writes p to the data file at offset off and applies the parity
+// delta to every parity shard. It returns the indexes of the data shards that
+// were written to.
+func (u *Updater) updateParity(p []byte, off int64) ([]int, error) {
+	if off < 0 || off+int64(len(p)) > u.md.Size {
+		return nil, fmt.Errorf("Cannot write %d bytes at offset %d: data size is %d", len(p), off, u.md.Size)
+	}
+
+	changedShards := make([]int, 0)
+	for written := int64(0); written < int64(len(p)); {
+		pos := off + written
+		shardIdx := int(pos / u.chunkSize)
+		shardOffset := pos % u.chunkSize
+		segmentLen := u.chunkSize - shardOffset
+		if remaining := int64(len(p)) - written; segmentLen > remaining {
+			segmentLen = remaining
+		}
+
+		err := u.updateSegment(shardIdx, shardOffset, p[written:written+segmentLen])
+		if err != nil {
+			return nil, err
+		}
+		changedShards = append(changedShards, shardIdx)
+		written += segmentLen
+	}
+	return changedShards, nil
+}
+
+// updateSegment replaces the bytes of a single data shard starting at
+// shardOffset with segment and updates the same columns of every parity shard.
+func (u *Updater) updateSegment(shardIdx int, shardOffset int64, segment []byte) error {
+	segmentLen := len(segment)
+	shards := make([][]byte, u.md.DataShards+u.md.ParityShards)
+	newDataShards := make([][]byte, u.md.DataShards)
+
+	oldData := make([]byte, segmentLen)
+	_, err := u.data.ReadAt(oldData, int64(shardIdx)*u.chunkSize+shardOffset)
+	if err != nil {
+		return fmt.Errorf("Error reading data shard %d: %s", shardIdx, err)
+	}
+	shards[shardIdx] = oldData
+	// Update modifies the new data in place, so hand it a copy.
+	newDataShards[shardIdx] = append([]byte(nil), segment...)
+
+	for i, parityFile := range u.parityFiles {
+		parity := make([]byte, segmentLen)
+		_, err := parityFile.ReadAt(parity, shardOffset)
+		if err != nil {
+			return fmt.Errorf("Error reading parity shard %d: %s", u.md.DataShards+i, err)
+		}
+		shards[u.md.DataShards+i] = parity
+	}
+
+	err = u.encoder.Update(shards, newDataShards)
+	if err != nil {
+		return fmt.Errorf("Error updating parity: %s", err)
+	}
+
+	_, err = u.data.WriteAt(segment, int64(shardIdx)*u.chunkSize+shardOffset)
+	if err != nil {
+		return fmt.Errorf("Error writing data shard %d: %s", shardIdx, err)
+	}
+	for i, parityFile := range u.parityFiles {
+		_, err := parityFile.WriteAt(shards[u.md.DataShards+i], shardOffset)
+		if err != nil {
+			return fmt.Errorf("Error writing parity shard %d: %s", u.md.DataShards+i, err)
+		}
+	}
+	return nil
+}
+
+// refreshHashes rehashes the given data shards and all parity shards and
+// stores the results in the Metadata.
+func (u *Updater) refreshHashes(dataShards []int) error {
+	hashes, err := u.hashShards(dataShards)
+	if err != nil {
+		return err
+	}
+	for i, shardHash := range hashes {
+		u.md.Hashes[i] = shardHash
+	}
+	return nil
+}
+
+// hashShards hashes the given data shards and all parity shards.
+func (u *Updater) hashShards(dataShards []int) (map[int]string, error) {
+	hashes := make(map[int]string)
+	chunks := SplitIntoPaddedChunks(u.data, u.md.Size, u.md.DataShards)
+	for _, i := range dataShards {
+		shardHash, err := hashReader(chunks[i])
+		if err != nil {
+			return nil, fmt.Errorf("Error hashing shard %d: %s", i, err)
+		}
+		hashes[i] = shardHash
+	}
+	for i, parityFile := range u.parityFiles {
+		parityShardIdx := u.md.DataShards + i
+		shardHash, err := hashReader(io.NewSectionReader(parityFile, 0, u.chunkSize))
+		if err != nil {
+			return nil, fmt.Errorf("Error hashing shard %d: %s", parityShardIdx, err)
+		}
+		hashes[parityShardIdx] = shardHash
+	}
+	return hashes, nil
+}
+
+// hashReader returns the hex-encoded sha256 hash of everything read from r.
+func hashReader(r io.Reader) (string, error) {
+	hasher := sha256.New()
+	_, err := io.Copy(hasher, r)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
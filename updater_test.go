@@ -0,0 +1,167 @@
+package rsutils
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func encodeTmp(t *testing.T, dataFile *os.File, dataShards, parityShards int, opts ...Option) (*Metadata, []*os.File) {
+	parityFiles := make([]*os.File, parityShards)
+	parityWriters := make([]io.Writer, parityShards)
+	for i := range parityFiles {
+		parityFiles[i] = CreateTMPFile(t, []byte{})
+		parityWriters[i] = parityFiles[i]
+	}
+	md, err := Encode(dataFile, dataShards, parityWriters, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return md, parityFiles
+}
+
+func TestUpdaterWriteAt(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		offset int64
+		data   []byte
+	}{
+		{"start of file", 0, []byte("Tiger")},
+		{"across shard boundary", 180, bytes.Repeat([]byte("X"), 20)},
+		{"last byte", int64(len(original)) - 1, []byte("!")},
+		{"whole file", 0, bytes.Repeat([]byte("Y"), len(original))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataFile := CreateTMPFile(t, original)
+			md, parityFiles := encodeTmp(t, dataFile, 3, 2)
+
+			updater, err := NewUpdater(dataFile, parityFiles, md)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := updater.WriteAt(tt.data, tt.offset)
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			if n != len(tt.data) {
+				t.Errorf("Expected to write %d bytes, got %d", len(tt.data), n)
+			}
+
+			expected := append([]byte(nil), original...)
+			copy(expected[tt.offset:], tt.data)
+			expectedFile := CreateTMPFile(t, expected)
+			expectedMd, expectedParityFiles := encodeTmp(t, expectedFile, 3, 2)
+
+			for i := range expectedMd.Hashes {
+				if md.Hashes[i] != expectedMd.Hashes[i] {
+					t.Errorf("Shard %d: got hash %s, expected %s", i, md.Hashes[i], expectedMd.Hashes[i])
+				}
+			}
+			for i := range parityFiles {
+				got, _ := ioutil.ReadFile(parityFiles[i].Name())
+				want, _ := ioutil.ReadFile(expectedParityFiles[i].Name())
+				if !bytes.Equal(got, want) {
+					t.Errorf("Parity shard %d differs from a full re-encode", i)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdaterWriteAtOutOfBounds(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDEFGH"))
+	md, parityFiles := encodeTmp(t, dataFile, 2, 1)
+	updater, err := NewUpdater(dataFile, parityFiles, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedErrMsg := "Cannot write 2 bytes at offset 7: data size is 8"
+	_, err = updater.WriteAt([]byte("IJ"), 7)
+	if err == nil || err.Error() != expectedErrMsg {
+		t.Errorf("Expected error '%s', got '%s'", expectedErrMsg, err)
+	}
+}
+
+func TestUpdaterRollsBackInterruptedWrite(t *testing.T) {
+	original, err := ioutil.ReadFile("testdata/uneven_input1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reopen := range []string{"NewUpdater", "Open"} {
+		t.Run(reopen, func(t *testing.T) {
+			dataFile := CreateTMPFile(t, original)
+			md, parityFiles := encodeTmp(t, dataFile, 3, 2)
+			originalParity, err := ioutil.ReadFile(parityFiles[0].Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			updater, err := NewUpdater(dataFile, parityFiles, md)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Crash after the data and parity were written, but before the
+			// hashes were refreshed.
+			newBytes := bytes.Repeat([]byte("X"), 20)
+			_, err = updater.journalWrite(newBytes, 180)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = updater.updateParity(newBytes, 180)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reopen == "Open" {
+				_, err = Open(dataFile, parityFiles, md)
+			} else {
+				_, err = NewUpdater(dataFile, parityFiles, md)
+			}
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			contents, err := ioutil.ReadFile(dataFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents, original) {
+				t.Errorf("Expected the write to the data file to be rolled back")
+			}
+			parity, err := ioutil.ReadFile(parityFiles[0].Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(parity, originalParity) {
+				t.Errorf("Expected the write to the parity file to be rolled back")
+			}
+			if _, err := os.Stat(updateJournalPath(dataFile)); !os.IsNotExist(err) {
+				t.Errorf("Expected the update journal to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdaterWriteAtLeavesNoJournal(t *testing.T) {
+	dataFile := CreateTMPFile(t, []byte("ABCDEFGH"))
+	md, parityFiles := encodeTmp(t, dataFile, 2, 1)
+	updater, err := NewUpdater(dataFile, parityFiles, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = updater.WriteAt([]byte("xyz"), 3)
+	if err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if _, err := os.Stat(updateJournalPath(dataFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the update journal to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package rsutils
+
+import "errors"
+
+// errReconstructionMismatch is returned when a reconstructed shard doesn't match
+// its hash in the Metadata. That means one of the shards it was reconstructed
+// from was bad despite having been verified, e.g. because it changed since or
+// a read returned garbage.
+var errReconstructionMismatch = errors.New("reconstructed shards don't match their hashes")
+
+// survivingShards returns the indexes of the data and global parity shards of
+// md that aren't broken.
+func survivingShards(md *Metadata, broken []int) []int {
+	isBroken := make(map[int]bool)
+	for _, i := range broken {
+		isBroken[i] = true
+	}
+	survivors := make([]int, 0, md.DataShards+md.ParityShards)
+	for i := 0; i < md.DataShards+md.ParityShards; i++ {
+		if !isBroken[i] {
+			survivors = append(survivors, i)
+		}
+	}
+	return survivors
+}
+
+// survivorExclusions returns the sets of survivors to leave out of a
+// reconstruction, in the order to try them: none first, then each survivor on
+// its own, as long as there is a parity shard to spare for it. That finds a
+// single bad survivor in at most len(survivors)+1 attempts; trying every
+// combination of several would grow combinatorially with the shard count.
+func survivorExclusions(survivors []int, spare int) [][]int {
+	exclusions := [][]int{{}}
+	if spare == 0 {
+		return exclusions
+	}
+	for _, survivor := range survivors {
+		exclusions = append(exclusions, []int{survivor})
+	}
+	return exclusions
+}
@@ -0,0 +1,158 @@
+package rsutils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSurvivorExclusions(t *testing.T) {
+	expected := "[[] [0] [2] [3]]"
+	if got := fmt.Sprint(survivorExclusions([]int{0, 2, 3}, 2)); got != expected {
+		t.Errorf("Got %s, expected %s", got, expected)
+	}
+	if got := fmt.Sprint(survivorExclusions([]int{0, 2, 3}, 1)); got != expected {
+		t.Errorf("Got %s, expected %s", got, expected)
+	}
+	expected = "[[]]"
+	if got := fmt.Sprint(survivorExclusions([]int{0, 2, 3}, 0)); got != expected {
+		t.Errorf("Got %s, expected %s", got, expected)
+	}
+}
+
+// decayingShard returns its contents intact until it has been read to the
+// end once, and with every byte inverted from then on, like a shard that goes
+// bad right after it was verified.
+type decayingShard struct {
+	io.ReadWriteSeeker
+	verified bool
+}
+
+func (s *decayingShard) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteSeeker.Read(p)
+	if s.verified {
+		for i := range p[:n] {
+			p[i] ^= 0xff
+		}
+	}
+	if err == io.EOF {
+		s.verified = true
+	}
+	return n, err
+}
+
+func TestShardManagerRepairLeavesOutBadSurvivor(t *testing.T) {
+	tests := []struct {
+		name         string
+		parityShards int
+		expectedErr  string
+	}{
+		{"spare parity", 2, ""},
+		{"no spare parity", 1, "Cannot repair data: reconstructed shards don't match their hashes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contents, md := splitBytes(t, []byte("The quick brown fox jumps over the lazy dog"), 3, tt.parityShards)
+			shardFiles := make([]*os.File, len(contents))
+			shards := make([]io.ReadWriteSeeker, len(contents))
+			for i := range contents {
+				shardFiles[i] = CreateTMPFile(t, contents[i])
+				shards[i] = shardFiles[i]
+				_, err := shardFiles[i].Seek(0, io.SeekStart)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			flipByte(t, shardFiles[0], 1)
+			corrupted, err := ioutil.ReadFile(shardFiles[0].Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			shards[1] = &decayingShard{ReadWriteSeeker: shards[1]}
+
+			err = NewShardManager(shards, md).Repair()
+			repaired, readErr := ioutil.ReadFile(shardFiles[0].Name())
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Errorf("Expected error '%s', got '%v'", tt.expectedErr, err)
+				}
+				if !bytes.Equal(repaired, corrupted) {
+					t.Errorf("Expected shard 0 to be left as it was")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			if !bytes.Equal(repaired, contents[0]) {
+				t.Errorf("Got '%s' in shard 0, expected '%s'", repaired, contents[0])
+			}
+		})
+	}
+}
+
+// corruptingObserver corrupts a parity file as soon as a repair starts, after
+// the shards were verified.
+type corruptingObserver struct {
+	BaseObserver
+	t          *testing.T
+	parityFile *os.File
+}
+
+func (o *corruptingObserver) OnRepairStarted(event ShardEvent) {
+	flipByte(o.t, o.parityFile, 0)
+}
+
+func TestFileDecoderRepairLeavesOutBadSurvivor(t *testing.T) {
+	tests := []struct {
+		name         string
+		parityShards int
+		expectedErr  string
+	}{
+		{"spare parity", 2, ""},
+		{"no spare parity", 1, "Cannot repair data: reconstructed shards don't match their hashes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := []byte("The quick brown fox jumps over the lazy dog")
+			dataFile := CreateTMPFile(t, original)
+			md, parityFiles := encodeTmp(t, dataFile, 3, tt.parityShards)
+			flipByte(t, dataFile, 1)
+			corrupted, err := ioutil.ReadFile(dataFile.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder, err := Open(dataFile, parityFiles, md, WithObserver(&corruptingObserver{t: t, parityFile: parityFiles[0]}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = decoder.Repair()
+			contents, readErr := ioutil.ReadFile(dataFile.Name())
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Errorf("Expected error '%s', got '%v'", tt.expectedErr, err)
+				}
+				if !bytes.Equal(contents, corrupted) {
+					t.Errorf("Expected the data file to be left as it was")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected nil error, got %s", err)
+			}
+			if !bytes.Equal(contents, original) {
+				t.Errorf("Got '%s', expected '%s'", contents, original)
+			}
+		})
+	}
+}
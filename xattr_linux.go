@@ -0,0 +1,32 @@
+package rsutils
+
+import "syscall"
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, xattrError(err)
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, xattrError(err)
+	}
+	return buf[:size], nil
+}
+
+func setXattr(path, name string, value []byte) error {
+	return xattrError(syscall.Setxattr(path, name, value, 0))
+}
+
+func xattrError(err error) error {
+	switch err {
+	case syscall.ENODATA:
+		return errXattrNotFound
+	// Files we may read but not own can't be given attributes either, so
+	// they are handled like a filesystem without them.
+	case syscall.ENOTSUP, syscall.EROFS, syscall.EACCES, syscall.EPERM:
+		return errXattrUnsupported
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package rsutils
+
+func getXattr(path, name string) ([]byte, error) {
+	return nil, errXattrUnsupported
+}
+
+func setXattr(path, name string, value []byte) error {
+	return errXattrUnsupported
+}